@@ -0,0 +1,190 @@
+package messages
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	AuditAuthRequest  auditKind = "authRequest"
+	AuditAuthResponse auditKind = "authResponse"
+	AuditLockResponse auditKind = "lockResponse"
+	AuditLockOffline  auditKind = "lockOffline"
+)
+
+type auditKind string
+
+type AuditEntry struct {
+	Kind          auditKind  `json:"kind"`
+	Time          time.Time  `json:"time"`
+	DeviceTime    int64      `json:"deviceTime,omitempty"`
+	ExtAddr       string     `json:"extAddr,omitempty"`
+	ShortAddr     string     `json:"shortAddr,omitempty"`
+	TransactionId uint32     `json:"transactionId"`
+	HashKey       string     `json:"hashKey,omitempty"`
+	AuthType      authType   `json:"authType,omitempty"`
+	AuthStatus    authStatus `json:"authStatus,omitempty"`
+	LockStatus    lockStatus `json:"lockStatus,omitempty"`
+	ChannelIds    []int      `json:"channelIds,omitempty"`
+}
+
+// AuditStore persists journal entries. Implementations must never modify or drop an appended entry and
+// must scan entries in the order they were appended.
+type AuditStore interface {
+	Append(entry AuditEntry) error
+	Scan(fn func(entry AuditEntry) bool) error
+}
+
+// AuditQuery selects entries matching every non-zero field. From is inclusive, To is exclusive.
+type AuditQuery struct {
+	ExtAddr string
+	HashKey string
+	Kinds   []auditKind
+	From    time.Time
+	To      time.Time
+	Limit   int
+}
+
+func (q *AuditQuery) Match(e *AuditEntry) bool {
+	if q.ExtAddr != "" && q.ExtAddr != e.ExtAddr {
+		return false
+	}
+
+	if q.HashKey != "" && q.HashKey != e.HashKey {
+		return false
+	}
+
+	if !q.From.IsZero() && e.Time.Before(q.From) {
+		return false
+	}
+
+	if !q.To.IsZero() && !e.Time.Before(q.To) {
+		return false
+	}
+
+	if len(q.Kinds) == 0 {
+		return true
+	}
+
+	for _, kind := range q.Kinds {
+		if kind == e.Kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+type AuditJournal struct {
+	Store AuditStore
+	Now   func() time.Time
+}
+
+func (j *AuditJournal) now() time.Time {
+	if j.Now != nil {
+		return j.Now()
+	}
+
+	return time.Now()
+}
+
+func (j *AuditJournal) RecordAuthRequest(extAddr string, a *AuthRequest) error {
+	return j.Store.Append(AuditEntry{
+		Kind:          AuditAuthRequest,
+		Time:          j.now(),
+		DeviceTime:    a.Timestamp,
+		ExtAddr:       extAddr,
+		TransactionId: a.TransactionId,
+		HashKey:       a.HashKey,
+		AuthType:      a.AuthType,
+		AuthStatus:    a.AuthStatus,
+		ChannelIds:    a.ChannelIds,
+	})
+}
+
+func (j *AuditJournal) RecordAuthResponse(a *AuthResponse) error {
+	return j.Store.Append(AuditEntry{
+		Kind:          AuditAuthResponse,
+		Time:          j.now(),
+		DeviceTime:    a.Timestamp,
+		ExtAddr:       a.ExtAddr,
+		ShortAddr:     a.ShortAddr,
+		TransactionId: a.TransactionId,
+		HashKey:       a.HashKey,
+		AuthType:      a.AuthType,
+		AuthStatus:    a.AuthStatus,
+		ChannelIds:    a.ChannelIds,
+	})
+}
+
+func (j *AuditJournal) RecordLockResponse(l *LockResponse) error {
+	return j.Store.Append(AuditEntry{
+		Kind:          AuditLockResponse,
+		Time:          j.now(),
+		ExtAddr:       l.ExtAddr,
+		ShortAddr:     l.ShortAddr,
+		TransactionId: l.TransactionId,
+		LockStatus:    l.LockActionStatus,
+		ChannelIds:    l.ChannelIds,
+	})
+}
+
+func (j *AuditJournal) RecordLockOffline(extAddr string, l *LockOffline) error {
+	return j.Store.Append(AuditEntry{
+		Kind:          AuditLockOffline,
+		Time:          j.now(),
+		ExtAddr:       extAddr,
+		TransactionId: l.TransactionId,
+		LockStatus:    OpenTimeoutLockStatus,
+	})
+}
+
+func (j *AuditJournal) Query(q AuditQuery) ([]AuditEntry, error) {
+	var entries []AuditEntry
+
+	err := j.Store.Scan(func(e AuditEntry) bool {
+		if q.Match(&e) {
+			entries = append(entries, e)
+		}
+
+		return q.Limit <= 0 || len(entries) < q.Limit
+	})
+
+	return entries, err
+}
+
+// FileAuditStore keeps the journal as newline delimited JSON in a single append-only file.
+type FileAuditStore struct {
+	lines *lineFile
+}
+
+func OpenFileAuditStore(path string) (*FileAuditStore, error) {
+	lines, err := openLineFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditStore{lines: lines}, nil
+}
+
+func (s *FileAuditStore) Append(entry AuditEntry) error {
+	return s.lines.append(entry)
+}
+
+func (s *FileAuditStore) Scan(fn func(entry AuditEntry) bool) error {
+	return s.lines.scan(func(line []byte) (bool, error) {
+		var entry AuditEntry
+
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return false, err
+		}
+
+		return fn(entry), nil
+	})
+}
+
+func (s *FileAuditStore) Close() error {
+	return s.lines.close()
+}
@@ -0,0 +1,102 @@
+package messages
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileAuditStoreRecoversTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	store, err := OpenFileAuditStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal := &AuditJournal{Store: store}
+
+	if err = journal.RecordLockOffline("a", &LockOffline{TransactionId: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.WriteString(`{"kind":"lockOffline","ext`)
+	file.Close()
+
+	if store, err = OpenFileAuditStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	journal.Store = store
+
+	if err = journal.RecordLockOffline("b", &LockOffline{TransactionId: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := journal.Query(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].ExtAddr != "a" || entries[1].ExtAddr != "b" {
+		bytes, _ := ioutil.ReadFile(path)
+		t.Fatalf("entries = %+v, file:\n%s", entries, bytes)
+	}
+}
+
+func TestAuditJournalQuery(t *testing.T) {
+	store, err := OpenFileAuditStore(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	c := &clock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	journal := &AuditJournal{Store: store, Now: c.Now}
+
+	record := []func() error{
+		func() error { return journal.RecordAuthRequest("a", &AuthRequest{HashKey: "card"}) },
+		func() error { return journal.RecordLockOffline("a", &LockOffline{TransactionId: 1}) },
+		func() error { return journal.RecordAuthResponse(&AuthResponse{ExtAddr: "b", HashKey: "card"}) },
+		func() error { return journal.RecordAuthRequest("a", &AuthRequest{HashKey: "card"}) },
+	}
+
+	for _, r := range record {
+		if err = r(); err != nil {
+			t.Fatal(err)
+		}
+
+		c.advance(time.Minute)
+	}
+
+	tests := []struct {
+		query AuditQuery
+		want  int
+	}{
+		{AuditQuery{}, 4},
+		{AuditQuery{ExtAddr: "a"}, 3},
+		{AuditQuery{HashKey: "card", Kinds: []auditKind{AuditAuthRequest}}, 2},
+		{AuditQuery{From: time.Date(2024, 3, 1, 12, 1, 0, 0, time.UTC), To: time.Date(2024, 3, 1, 12, 3, 0, 0, time.UTC)}, 2},
+		{AuditQuery{ExtAddr: "a", Limit: 2}, 2},
+	}
+
+	for i, tt := range tests {
+		entries, err := journal.Query(tt.query)
+
+		if err != nil || len(entries) != tt.want {
+			t.Errorf("query %d: %d entries, %v, want %d", i, len(entries), err, tt.want)
+		}
+	}
+}
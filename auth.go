@@ -59,6 +59,19 @@ func (s *authStatus) MarshalJSON() ([]byte, error) {
 	return json.Marshal((*string)(s))
 }
 
+func (s authStatus) Failed() bool {
+	switch s {
+	case FailedOfflineStatus, FailedPrivacyStatus, FailedOnlineStatus, NotFoundOfflineStatus, ErrorEncryptionStatus:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s authStatus) Succeeded() bool {
+	return s == SuccessOfflineStatus || s == SuccessOnlineStatus
+}
+
 type authType string
 
 func (t *authType) UnmarshalJSON(bytes []byte) (err error) {
@@ -0,0 +1,417 @@
+package messages
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	DeviceAlertScope   authAlertScope = "device"
+	HashKeyAlertScope  authAlertScope = "hashKey"
+	AuthTypeAlertScope authAlertScope = "authType"
+)
+
+type authAlertScope string
+
+// AuthAlertRule raises an alert when, within Window, the failures for one key of Scope reach MaxFailures or
+// their share of at least MinEvents events reaches MaxFailureRate. Zero thresholds are disabled.
+type AuthAlertRule struct {
+	Scope          authAlertScope
+	Window         time.Duration
+	MaxFailures    int
+	MaxFailureRate float64
+	MinEvents      int
+	Lockout        bool
+}
+
+type AuthCredential struct {
+	ExtAddr string `json:"extAddr"`
+	HashKey string `json:"hashKey"`
+}
+
+type AuthAlert struct {
+	Rule        AuthAlertRule
+	Key         string
+	Failures    int
+	Events      int
+	Credentials []AuthCredential
+	At          time.Time
+}
+
+type authSample struct {
+	at         time.Time
+	credential AuthCredential
+	failed     bool
+}
+
+type authWindow struct {
+	samples   []authSample
+	alertedAt time.Time
+}
+
+// expire drops the samples older than window at now.
+func (w *authWindow) expire(now time.Time, window time.Duration) {
+	expired := 0
+	for expired < len(w.samples) && now.Sub(w.samples[expired].at) > window {
+		expired++
+	}
+
+	w.samples = w.samples[expired:]
+}
+
+func (w *authWindow) failures() (failures int, credentials []AuthCredential) {
+	seen := make(map[AuthCredential]bool)
+
+	for _, s := range w.samples {
+		if !s.failed {
+			continue
+		}
+
+		failures++
+
+		if !seen[s.credential] {
+			seen[s.credential] = true
+			credentials = append(credentials, s.credential)
+		}
+	}
+
+	return
+}
+
+type AuthAnomalyDetector struct {
+	Rules   []AuthAlertRule
+	OnAlert func(AuthAlert)
+	Lockout *AuthLockout
+	Now     func() time.Time
+
+	mu      sync.Mutex
+	windows []map[string]*authWindow
+}
+
+func (d *AuthAnomalyDetector) ObserveRequest(ctx context.Context, extAddr string, a *AuthRequest) ([]AuthAlert, error) {
+	return d.observe(ctx, AuthCredential{extAddr, a.HashKey}, a.AuthType, a.AuthStatus)
+}
+
+func (d *AuthAnomalyDetector) ObserveResponse(ctx context.Context, a *AuthResponse) ([]AuthAlert, error) {
+	return d.observe(ctx, AuthCredential{a.ExtAddr, a.HashKey}, a.AuthType, a.AuthStatus)
+}
+
+func (d *AuthAnomalyDetector) observe(ctx context.Context, credential AuthCredential, t authType, status authStatus) ([]AuthAlert, error) {
+	switch status {
+	case NoneStatus, VerifyOnlineStatus:
+		return nil, nil
+	}
+
+	now := time.Now()
+	if d.Now != nil {
+		now = d.Now()
+	}
+
+	sample := authSample{at: now, credential: credential, failed: status.Failed()}
+	alerts := d.evaluate(sample, t)
+
+	var errs []error
+
+	for _, alert := range alerts {
+		if d.OnAlert != nil {
+			d.OnAlert(alert)
+		}
+
+		if !alert.Rule.Lockout || d.Lockout == nil {
+			continue
+		}
+
+		for _, c := range alert.Credentials {
+			if err := d.Lockout.Lock(ctx, c.ExtAddr, c.HashKey, now); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return alerts, LockoutError{errs}
+	}
+
+	return alerts, nil
+}
+
+func (d *AuthAnomalyDetector) evaluate(sample authSample, t authType) []AuthAlert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.windows) < len(d.Rules) {
+		d.windows = append(d.windows, make(map[string]*authWindow))
+	}
+
+	var alerts []AuthAlert
+
+	for i, rule := range d.Rules {
+		var key string
+
+		switch rule.Scope {
+		case DeviceAlertScope:
+			key = sample.credential.ExtAddr
+		case HashKeyAlertScope:
+			key = sample.credential.HashKey
+		case AuthTypeAlertScope:
+			key = string(t)
+		default:
+			continue
+		}
+
+		// Windows of keys that saw no event for a while are dropped, so keys that are tried once, like the random
+		// hashKeys of a brute force, do not pile up.
+		for k, w := range d.windows[i] {
+			if w.expire(sample.at, rule.Window); len(w.samples) == 0 {
+				delete(d.windows[i], k)
+			}
+		}
+
+		w, ok := d.windows[i][key]
+		if !ok {
+			w = new(authWindow)
+			d.windows[i][key] = w
+		}
+
+		w.samples = append(w.samples, sample)
+
+		if !sample.failed || (!w.alertedAt.IsZero() && sample.at.Sub(w.alertedAt) <= rule.Window) {
+			continue
+		}
+
+		failures, credentials := w.failures()
+		events := len(w.samples)
+
+		triggered := rule.MaxFailures > 0 && failures >= rule.MaxFailures
+		triggered = triggered || rule.MaxFailureRate > 0 && events >= rule.MinEvents &&
+			float64(failures)/float64(events) >= rule.MaxFailureRate
+
+		if !triggered {
+			continue
+		}
+
+		w.alertedAt = sample.at
+		alerts = append(alerts, AuthAlert{
+			Rule:        rule,
+			Key:         key,
+			Failures:    failures,
+			Events:      events,
+			Credentials: credentials,
+			At:          sample.at,
+		})
+	}
+
+	return alerts
+}
+
+type LockedCredential struct {
+	AuthCredential
+	Until time.Time   `json:"until"`
+	Data  StorageData `json:"data"`
+}
+
+// LockoutStore keeps locked credentials together with the records needed to restore them.
+type LockoutStore interface {
+	Load() ([]LockedCredential, error)
+	Save(credential LockedCredential) error
+	Delete(credential AuthCredential) error
+}
+
+// FileLockoutStore keeps all locked credentials in one JSON file at Path, rewritten on every change.
+type FileLockoutStore struct {
+	Path string
+
+	records recordFile
+}
+
+func (s *FileLockoutStore) Load() ([]LockedCredential, error) {
+	var credentials []LockedCredential
+
+	err := s.records.load(s.Path, func(record []byte) error {
+		var c LockedCredential
+
+		if err := json.Unmarshal(record, &c); err != nil {
+			return err
+		}
+
+		credentials = append(credentials, c)
+
+		return nil
+	})
+
+	return credentials, err
+}
+
+func (s *FileLockoutStore) Save(credential LockedCredential) error {
+	return s.records.put(s.Path, credential.ExtAddr+"/"+credential.HashKey, credential)
+}
+
+func (s *FileLockoutStore) Delete(credential AuthCredential) error {
+	return s.records.delete(s.Path, credential.ExtAddr+"/"+credential.HashKey)
+}
+
+// AuthLockout temporarily removes a credential from a device and restores the saved record once Duration has passed.
+// The record is saved to Store before the credential is deleted, so a lockout outlives a restart of the process.
+type AuthLockout struct {
+	Storage  *StorageClient
+	Store    LockoutStore
+	Duration time.Duration
+
+	mu     sync.Mutex
+	locked map[AuthCredential]*LockedCredential
+}
+
+// load reads the locked credentials from Store on first use. It must be called with mu held.
+func (l *AuthLockout) load() error {
+	if l.locked != nil {
+		return nil
+	}
+
+	if l.Store == nil {
+		return StoreMissing{"credential lockout"}
+	}
+
+	credentials, err := l.Store.Load()
+
+	if err != nil {
+		return err
+	}
+
+	l.locked = make(map[AuthCredential]*LockedCredential, len(credentials))
+
+	for i := range credentials {
+		l.locked[credentials[i].AuthCredential] = &credentials[i]
+	}
+
+	return nil
+}
+
+func (l *AuthLockout) Lock(ctx context.Context, extAddr, hashKey string, now time.Time) error {
+	credential := AuthCredential{extAddr, hashKey}
+
+	l.mu.Lock()
+	if err := l.load(); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+
+	if locked, ok := l.locked[credential]; ok {
+		extended := *locked
+		extended.Until = now.Add(l.Duration)
+
+		err := l.Store.Save(extended)
+		if err == nil {
+			*locked = extended
+		}
+
+		l.mu.Unlock()
+		return err
+	}
+	l.mu.Unlock()
+
+	r, err := l.Storage.GetKey(ctx, extAddr, hashKey)
+
+	if isStorageStatus(err, StorageResponseStatusErrorKeyNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	data := r.StorageData
+	data.Status = StorageResponseStatusOk
+
+	locked := &LockedCredential{AuthCredential: credential, Until: now.Add(l.Duration), Data: data}
+
+	l.mu.Lock()
+	if err = l.Store.Save(*locked); err == nil {
+		l.locked[credential] = locked
+	}
+	l.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = l.Storage.DeleteKey(ctx, extAddr, hashKey); err != nil {
+		l.mu.Lock()
+		if l.Store.Delete(credential) == nil {
+			delete(l.locked, credential)
+		}
+		l.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (l *AuthLockout) Locked() ([]LockedCredential, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	locked := make([]LockedCredential, 0, len(l.locked))
+	for _, c := range l.locked {
+		locked = append(locked, *c)
+	}
+
+	return locked, nil
+}
+
+// Release restores every credential whose lockout has ended by now. Credentials that fail to restore stay locked
+// and are retried on the next call.
+func (l *AuthLockout) Release(ctx context.Context, now time.Time) ([]LockedCredential, error) {
+	var due []LockedCredential
+
+	l.mu.Lock()
+	if err := l.load(); err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+
+	for _, c := range l.locked {
+		if !now.Before(c.Until) {
+			due = append(due, *c)
+		}
+	}
+	l.mu.Unlock()
+
+	var released []LockedCredential
+	var errs []error
+
+	for _, c := range due {
+		_, err := l.Storage.AddKey(ctx, c.ExtAddr, c.Data)
+
+		if err != nil && !isStorageStatus(err, StorageResponseStatusErrorKeyAlreadyExists) {
+			errs = append(errs, err)
+			continue
+		}
+
+		l.mu.Lock()
+		if err = l.Store.Delete(c.AuthCredential); err == nil {
+			delete(l.locked, c.AuthCredential)
+		}
+		l.mu.Unlock()
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		released = append(released, c)
+	}
+
+	if len(errs) > 0 {
+		return released, LockoutError{errs}
+	}
+
+	return released, nil
+}
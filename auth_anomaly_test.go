@@ -0,0 +1,163 @@
+package messages
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthLockoutSurvivesRestart(t *testing.T) {
+	device := &fakeStorage{}
+	device.put("lock", StorageData{HashKey: "key", Flags: Flags{MasterKey: true}})
+
+	path := filepath.Join(t.TempDir(), "lockout.json")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	lockout := &AuthLockout{Storage: &StorageClient{Transport: device}, Store: &FileLockoutStore{Path: path}, Duration: time.Hour}
+
+	if err := lockout.Lock(context.Background(), "lock", "key", now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := device.key("lock", "key"); ok {
+		t.Fatal("locked key is still on the device")
+	}
+
+	restarted := &AuthLockout{Storage: &StorageClient{Transport: device}, Store: &FileLockoutStore{Path: path}, Duration: time.Hour}
+
+	released, err := restarted.Release(context.Background(), now.Add(time.Hour))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(released) != 1 {
+		t.Fatalf("released %d credentials, want 1", len(released))
+	}
+
+	if data, ok := device.key("lock", "key"); !ok || !data.Flags.MasterKey {
+		t.Fatalf("key was not restored: %+v", data)
+	}
+
+	if locked, err := restarted.Locked(); err != nil || len(locked) != 0 {
+		t.Fatalf("locked = %v, %v", locked, err)
+	}
+}
+
+func TestAuthLockoutRequiresStore(t *testing.T) {
+	device := &fakeStorage{}
+	device.put("lock", StorageData{HashKey: "key"})
+
+	lockout := &AuthLockout{Storage: &StorageClient{Transport: device}, Duration: time.Hour}
+
+	if err := lockout.Lock(context.Background(), "lock", "key", time.Now()); err == nil {
+		t.Fatal("lock without a store succeeded")
+	}
+
+	if _, ok := device.key("lock", "key"); !ok {
+		t.Fatal("key was deleted without a store")
+	}
+}
+
+// clock is a settable time source for Now hooks.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestAuthAnomalyDetectorMaxFailures(t *testing.T) {
+	c := &clock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	rule := AuthAlertRule{Scope: DeviceAlertScope, Window: time.Minute, MaxFailures: 3}
+	detector := &AuthAnomalyDetector{Rules: []AuthAlertRule{rule}, Now: c.Now}
+
+	fail := func(extAddr, hashKey string) []AuthAlert {
+		alerts, err := detector.ObserveRequest(context.Background(), extAddr, &AuthRequest{HashKey: hashKey, AuthType: NFCType, AuthStatus: FailedOfflineStatus})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return alerts
+	}
+
+	for i := 0; i < 2; i++ {
+		if alerts := fail("lock", "k"); len(alerts) != 0 {
+			t.Fatalf("alerted after %d failures", i+1)
+		}
+		c.advance(10 * time.Second)
+	}
+
+	if alerts := fail("other", "k"); len(alerts) != 0 {
+		t.Fatal("failures of another device counted")
+	}
+
+	alerts := fail("lock", "x")
+	if len(alerts) != 1 || alerts[0].Key != "lock" || alerts[0].Failures != 3 || len(alerts[0].Credentials) != 2 {
+		t.Fatalf("alerts %+v", alerts)
+	}
+
+	if alerts = fail("lock", "k"); len(alerts) != 0 {
+		t.Fatal("alerted again within the window")
+	}
+
+	c.advance(2 * time.Minute)
+
+	if alerts = fail("lock", "k"); len(alerts) != 0 {
+		t.Fatal("failures outside the window counted")
+	}
+}
+
+func TestAuthAnomalyDetectorFailureRate(t *testing.T) {
+	c := &clock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	rule := AuthAlertRule{Scope: AuthTypeAlertScope, Window: time.Hour, MaxFailureRate: 0.5, MinEvents: 4}
+	detector := &AuthAnomalyDetector{Rules: []AuthAlertRule{rule}, Now: c.Now}
+
+	observe := func(status authStatus) []AuthAlert {
+		c.advance(time.Second)
+
+		alerts, err := detector.ObserveResponse(context.Background(), &AuthResponse{ExtAddr: "lock", HashKey: "k", AuthType: QRType, AuthStatus: status})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return alerts
+	}
+
+	observe(SuccessOfflineStatus)
+	observe(SuccessOfflineStatus)
+
+	if alerts := observe(FailedOfflineStatus); len(alerts) != 0 {
+		t.Fatal("alerted below MinEvents")
+	}
+
+	if alerts := observe(FailedOfflineStatus); len(alerts) != 1 || alerts[0].Key != string(QRType) || alerts[0].Events != 4 {
+		t.Fatalf("alerts %+v", alerts)
+	}
+}
+
+func TestAuthAnomalyDetectorHashKeyScopeDropsIdleWindows(t *testing.T) {
+	c := &clock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	rule := AuthAlertRule{Scope: HashKeyAlertScope, Window: time.Minute, MaxFailures: 2}
+	detector := &AuthAnomalyDetector{Rules: []AuthAlertRule{rule}, Now: c.Now}
+
+	for i := 0; i < 100; i++ {
+		request := &AuthRequest{HashKey: string(rune('a'+i%26)) + string(rune('0'+i/26)), AuthType: NFCType, AuthStatus: FailedOfflineStatus}
+
+		if _, err := detector.ObserveRequest(context.Background(), "lock", request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.advance(2 * time.Minute)
+
+	if _, err := detector.ObserveRequest(context.Background(), "lock", &AuthRequest{HashKey: "last", AuthType: NFCType, AuthStatus: FailedOfflineStatus}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(detector.windows[0]); n != 1 {
+		t.Fatalf("kept %d windows, want only the one of the last hashKey", n)
+	}
+}
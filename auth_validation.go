@@ -0,0 +1,92 @@
+package messages
+
+import (
+	"sync"
+	"time"
+)
+
+// AuthValidator rejects replayed or delayed auth events. Timestamps are the wall clock of the device, read in the
+// zone Location returns for it, UTC without Location; events without a timestamp are only checked for duplicate
+// transaction ids. Zero limits disable the corresponding check.
+type AuthValidator struct {
+	MaxClockSkew time.Duration
+	ReplayWindow time.Duration
+	Location     func(extAddr string) *time.Location
+	Now          func() time.Time
+
+	mu      sync.Mutex
+	devices map[string]*authValidationState
+}
+
+type authValidationState struct {
+	lastTimestamp int64
+	transactions  map[uint32]time.Time
+}
+
+func (v *AuthValidator) ValidateRequest(extAddr string, a *AuthRequest) error {
+	return v.validate(extAddr, a.TransactionId, a.Timestamp)
+}
+
+func (v *AuthValidator) ValidateResponse(a *AuthResponse) error {
+	return v.validate(a.ExtAddr, a.TransactionId, a.Timestamp)
+}
+
+func (v *AuthValidator) validate(extAddr string, transactionId uint32, timestamp int64) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.devices == nil {
+		v.devices = make(map[string]*authValidationState)
+	}
+
+	state, ok := v.devices[extAddr]
+	if !ok {
+		state = &authValidationState{transactions: make(map[uint32]time.Time)}
+		v.devices[extAddr] = state
+	}
+
+	for id, seen := range state.transactions {
+		if now.Sub(seen) > v.ReplayWindow {
+			delete(state.transactions, id)
+		}
+	}
+
+	if _, seen := state.transactions[transactionId]; seen && v.ReplayWindow > 0 {
+		return DuplicateTransactionError{ExtAddr: extAddr, TransactionId: transactionId}
+	}
+
+	if timestamp != 0 {
+		loc := time.UTC
+		if v.Location != nil {
+			if l := v.Location(extAddr); l != nil {
+				loc = l
+			}
+		}
+
+		skew := now.Sub(deviceTime(timestamp, loc))
+		if skew < 0 {
+			skew = -skew
+		}
+
+		if v.MaxClockSkew > 0 && skew > v.MaxClockSkew {
+			return ClockSkewError{ExtAddr: extAddr, Timestamp: timestamp, Skew: skew}
+		}
+
+		if timestamp < state.lastTimestamp {
+			return StaleTimestampError{ExtAddr: extAddr, Timestamp: timestamp, LastAccepted: state.lastTimestamp}
+		}
+
+		state.lastTimestamp = timestamp
+	}
+
+	if v.ReplayWindow > 0 {
+		state.transactions[transactionId] = now
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package messages
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthValidatorReadsDeviceWallClock(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	now := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	v := &AuthValidator{
+		MaxClockSkew: time.Minute,
+		Location:     func(string) *time.Location { return berlin },
+		Now:          func() time.Time { return now },
+	}
+
+	if err = v.ValidateRequest("lock", &AuthRequest{TransactionId: 1, Timestamp: deviceSeconds(now, berlin)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.ValidateRequest("lock", &AuthRequest{TransactionId: 2, Timestamp: now.Unix()}); err == nil {
+		t.Fatal("a timestamp two hours off the device clock was accepted")
+	}
+}
+
+func TestAuthValidatorRejectsDuplicateTransactionId(t *testing.T) {
+	c := &clock{now: time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)}
+	v := &AuthValidator{ReplayWindow: time.Minute, Now: c.Now}
+
+	if err := v.ValidateRequest("lock", &AuthRequest{TransactionId: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.ValidateRequest("lock", &AuthRequest{TransactionId: 7}); err != (DuplicateTransactionError{ExtAddr: "lock", TransactionId: 7}) {
+		t.Fatalf("replayed transaction id: %v", err)
+	}
+
+	if err := v.ValidateRequest("other", &AuthRequest{TransactionId: 7}); err != nil {
+		t.Fatalf("transaction id of another device: %v", err)
+	}
+
+	c.advance(2 * time.Minute)
+
+	if err := v.ValidateRequest("lock", &AuthRequest{TransactionId: 7}); err != nil {
+		t.Fatalf("transaction id reused after the replay window: %v", err)
+	}
+}
+
+func TestAuthValidatorRejectsStaleTimestamp(t *testing.T) {
+	now := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	v := &AuthValidator{Now: func() time.Time { return now }}
+
+	if err := v.ValidateRequest("lock", &AuthRequest{TransactionId: 1, Timestamp: now.Unix()}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := v.ValidateRequest("lock", &AuthRequest{TransactionId: 2, Timestamp: now.Unix() - 5})
+	if err != (StaleTimestampError{ExtAddr: "lock", Timestamp: now.Unix() - 5, LastAccepted: now.Unix()}) {
+		t.Fatalf("older timestamp: %v", err)
+	}
+
+	if err = v.ValidateRequest("lock", &AuthRequest{TransactionId: 3, Timestamp: now.Unix()}); err != nil {
+		t.Fatalf("repeated timestamp: %v", err)
+	}
+
+	if err = v.ValidateRequest("other", &AuthRequest{TransactionId: 4, Timestamp: now.Unix() - 5}); err != nil {
+		t.Fatalf("timestamp of another device: %v", err)
+	}
+}
@@ -93,3 +93,30 @@ func (e *eventResponse) MarshalJSON() ([]byte, error) {
 		"event": (*response)(e),
 	})
 }
+
+// peekEvent returns the event type of a device message together with the message itself, taken out of its
+// {"event": ...} envelope when it arrives wrapped.
+func peekEvent(bytes []byte) (eventType, []byte, error) {
+	var m struct {
+		EventType eventType       `json:"eventType"`
+		Event     json.RawMessage `json:"event"`
+	}
+
+	if err := json.Unmarshal(bytes, &m); err != nil {
+		return "", nil, err
+	}
+
+	if m.EventType != "" || len(m.Event) == 0 {
+		return m.EventType, bytes, nil
+	}
+
+	var inner struct {
+		EventType eventType `json:"eventType"`
+	}
+
+	if err := json.Unmarshal(m.Event, &inner); err != nil {
+		return "", nil, err
+	}
+
+	return inner.EventType, m.Event, nil
+}
@@ -45,13 +45,14 @@ const (
 	VolumeMaximum buzzerVolume = "maximum"
 )
 
+// deviceType and deviceRole accept the empty value, which stands for a value that is not known.
 type deviceType string
 
 func (t *deviceType) UnmarshalJSON(bytes []byte) (err error) {
 	defer func() {
 		if t != nil {
 			switch *t {
-			case DeviceTypeNone, DeviceTypeFCLock, DeviceTypeWallReader, DeviceTypeFCRelay:
+			case "", DeviceTypeNone, DeviceTypeFCLock, DeviceTypeWallReader, DeviceTypeFCRelay:
 			default:
 				err = InvalidDeviceType{*t}
 			}
@@ -65,7 +66,7 @@ func (t *deviceType) UnmarshalJSON(bytes []byte) (err error) {
 func (t *deviceType) MarshalJSON() ([]byte, error) {
 	if t != nil {
 		switch *t {
-		case DeviceTypeNone, DeviceTypeFCLock, DeviceTypeWallReader, DeviceTypeFCRelay:
+		case "", DeviceTypeNone, DeviceTypeFCLock, DeviceTypeWallReader, DeviceTypeFCRelay:
 		default:
 			return nil, InvalidDeviceType{*t}
 		}
@@ -80,7 +81,7 @@ func (r *deviceRole) UnmarshalJSON(bytes []byte) (err error) {
 	defer func() {
 		if r != nil {
 			switch *r {
-			case DeviceRoleNone, DeviceRoleStandalone, DeviceRoleMaster, DeviceRoleSlave:
+			case "", DeviceRoleNone, DeviceRoleStandalone, DeviceRoleMaster, DeviceRoleSlave:
 			default:
 				err = InvalidDeviceRole{*r}
 			}
@@ -94,7 +95,7 @@ func (r *deviceRole) UnmarshalJSON(bytes []byte) (err error) {
 func (r *deviceRole) MarshalJSON() ([]byte, error) {
 	if r != nil {
 		switch *r {
-		case DeviceRoleNone, DeviceRoleStandalone, DeviceRoleMaster, DeviceRoleSlave:
+		case "", DeviceRoleNone, DeviceRoleStandalone, DeviceRoleMaster, DeviceRoleSlave:
 		default:
 			return nil, InvalidDeviceRole{*r}
 		}
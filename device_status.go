@@ -1,6 +1,9 @@
 package messages
 
-import "github.com/goccy/go-json"
+import (
+	"github.com/goccy/go-json"
+	"time"
+)
 
 const (
 	DeviceStatusRequestEvent  eventType = "deviceStatusReq"
@@ -136,3 +139,20 @@ func (d *DeviceStatusResponse) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(&e)
 }
+
+// DeviceLocation returns the fixed zone of a device whose clock runs offset ahead of UTC.
+func DeviceLocation(offset time.Duration) *time.Location {
+	return time.FixedZone("", int(offset/time.Second))
+}
+
+// Location returns the zone of the device. The protocol does not document the unit of Timezone, so the caller
+// passes the unit its firmware reports it in, e.g. time.Minute.
+func (d *DeviceStatusResponse) Location(unit time.Duration) *time.Location {
+	return DeviceLocation(time.Duration(d.Timezone) * unit)
+}
+
+// Clock returns the reported device time, which like TimeKey bounds is the device wall clock in unix seconds.
+// unit is the unit of Timezone, as for Location.
+func (d *DeviceStatusResponse) Clock(unit time.Duration) time.Time {
+	return deviceTime(d.Time, d.Location(unit))
+}
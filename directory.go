@@ -0,0 +1,81 @@
+package messages
+
+import (
+	"sort"
+	"sync"
+)
+
+// DeviceDirectory lists the devices known to the cloud and tells whether each of them is reachable right now.
+type DeviceDirectory interface {
+	Devices() []string
+	Online(extAddr string) bool
+}
+
+// gateway returns the gateway of a device when the directory, like NetworkDirectory, knows which one it is on.
+func gateway(directory DeviceDirectory, extAddr string) string {
+	if d, ok := directory.(interface{ Gateway(string) (string, bool) }); ok {
+		gateway, _ := d.Gateway(extAddr)
+		return gateway
+	}
+
+	return ""
+}
+
+// NetworkDirectory is a DeviceDirectory built from the latest GetNetworkInfoResponse of every gateway.
+type NetworkDirectory struct {
+	mu       sync.RWMutex
+	networks map[string]GetNetworkInfoResponse
+}
+
+func (d *NetworkDirectory) Update(info GetNetworkInfoResponse) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.networks == nil {
+		d.networks = make(map[string]GetNetworkInfoResponse)
+	}
+
+	d.networks[info.ExtAddr] = info
+}
+
+func (d *NetworkDirectory) Devices() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var devices []string
+
+	for _, network := range d.networks {
+		for _, device := range network.Devices {
+			devices = append(devices, device.ExtAddr)
+		}
+	}
+
+	sort.Strings(devices)
+
+	return devices
+}
+
+func (d *NetworkDirectory) Device(extAddr string) (device Device, gateway string, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, network := range d.networks {
+		for _, device = range network.Devices {
+			if device.ExtAddr == extAddr {
+				return device, network.ExtAddr, true
+			}
+		}
+	}
+
+	return Device{}, "", false
+}
+
+func (d *NetworkDirectory) Online(extAddr string) bool {
+	device, _, ok := d.Device(extAddr)
+	return ok && device.IsActive()
+}
+
+func (d *NetworkDirectory) Gateway(extAddr string) (string, bool) {
+	_, gateway, ok := d.Device(extAddr)
+	return gateway, ok
+}
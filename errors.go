@@ -1,6 +1,11 @@
 package messages
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type InvalidEventType struct {
 	Got eventType
@@ -53,7 +58,7 @@ func (e InvalidLockStatus) Error() string {
 	return fmt.Sprintf("invalid authentication status %s! Expected %+q", e.Got, [...]lockStatus{
 		NoneLockStatus, ExtRelayStateLockStatus, LockOpenedLockStatus, LockClosedLockStatus,
 		DriverOnLockStatus, ErrorLockAlreadyOpenLockStatus, ErrorLockAlreadyClosedLockStatus,
-		ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus,
+		ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus, OpenTimeoutLockStatus,
 	})
 }
 
@@ -159,3 +164,429 @@ func (e InvalidBuzzerVolume) Error() string {
 		VolumeOff, VolumeMedium, VolumeMaximum,
 	})
 }
+
+type StorageStatusError struct {
+	ExtAddr string
+	HashKey string
+	Status  storageResponseStatus
+}
+
+func (e StorageStatusError) Error() string {
+	return fmt.Sprintf("storage request for hashKey %s on device %s failed with status %d", e.HashKey, e.ExtAddr, e.Status)
+}
+
+type LockoutError struct {
+	Errors []error
+}
+
+func (e LockoutError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+
+	return "credential lockout failed: " + strings.Join(messages, "; ")
+}
+
+func (e LockoutError) Unwrap() []error { return e.Errors }
+
+type StoreMissing struct {
+	Component string
+}
+
+func (e StoreMissing) Error() string {
+	return e.Component + " has no store to persist its state"
+}
+
+type VisitorError struct {
+	HashKey string
+	Errors  []error
+}
+
+func (e VisitorError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+
+	if e.HashKey == "" {
+		return "visitor credentials failed: " + strings.Join(messages, "; ")
+	}
+
+	return "visitor credential " + e.HashKey + " failed: " + strings.Join(messages, "; ")
+}
+
+func (e VisitorError) Unwrap() []error { return e.Errors }
+
+type RevocationError struct {
+	HashKey string
+	Devices []string
+}
+
+func (e RevocationError) Error() string {
+	return fmt.Sprintf("revocation of hashKey %s failed on devices %q", e.HashKey, e.Devices)
+}
+
+type FlushError struct {
+	ExtAddr  string
+	HashKeys []string
+}
+
+func (e FlushError) Error() string {
+	return fmt.Sprintf("queued revocations of hashKeys %q on device %s were not delivered", e.HashKeys, e.ExtAddr)
+}
+
+type ClockSkewError struct {
+	ExtAddr   string
+	Timestamp int64
+	Skew      time.Duration
+}
+
+func (e ClockSkewError) Error() string {
+	return fmt.Sprintf("auth event from device %s has timestamp %d skewed by %s", e.ExtAddr, e.Timestamp, e.Skew)
+}
+
+type DuplicateTransactionError struct {
+	ExtAddr       string
+	TransactionId uint32
+}
+
+func (e DuplicateTransactionError) Error() string {
+	return fmt.Sprintf("auth event from device %s repeats transaction id %d", e.ExtAddr, e.TransactionId)
+}
+
+type StaleTimestampError struct {
+	ExtAddr      string
+	Timestamp    int64
+	LastAccepted int64
+}
+
+func (e StaleTimestampError) Error() string {
+	return fmt.Sprintf("auth event from device %s has timestamp %d older than last accepted %d", e.ExtAddr, e.Timestamp, e.LastAccepted)
+}
+
+type ReconcileError struct {
+	ExtAddr  string
+	HashKeys []string
+}
+
+func (e ReconcileError) Error() string {
+	return fmt.Sprintf("reconciliation of device %s failed for hashKeys %q", e.ExtAddr, e.HashKeys)
+}
+
+type InvalidPlanOperation struct {
+	Got planOperation
+}
+
+func (e InvalidPlanOperation) Error() string {
+	return fmt.Sprintf("invalid plan operation %s! Expected %+q", e.Got, [...]planOperation{
+		PlanDelete, PlanUpdate, PlanAdd,
+	})
+}
+
+type InvalidPlanStep struct {
+	Reason string
+}
+
+func (e InvalidPlanStep) Error() string {
+	return "invalid plan step: " + e.Reason
+}
+
+type PlanDriftError struct {
+	ExtAddr string
+	HashKey string
+}
+
+func (e PlanDriftError) Error() string {
+	return fmt.Sprintf("hashKey %s on device %s changed since the plan was made", e.HashKey, e.ExtAddr)
+}
+
+type PlanStepError struct {
+	Index   int
+	ExtAddr string
+	HashKey string
+	Err     error
+}
+
+func (e PlanStepError) Error() string {
+	return fmt.Sprintf("plan step %d for hashKey %s on device %s failed: %s", e.Index, e.HashKey, e.ExtAddr, e.Err)
+}
+
+func (e PlanStepError) Unwrap() error { return e.Err }
+
+type FlashModelMissing struct {
+	DeviceType deviceType
+}
+
+func (e FlashModelMissing) Error() string {
+	return "no flash model for device type " + string(e.DeviceType)
+}
+
+type FlashFullError struct {
+	ExtAddr  string
+	Used     int
+	Capacity int
+	Missing  int
+}
+
+func (e FlashFullError) Error() string {
+	if e.Missing > 0 {
+		return fmt.Sprintf("flash of device %s is full (%d of %d bytes), %d bytes cannot be freed", e.ExtAddr, e.Used, e.Capacity, e.Missing)
+	}
+
+	return fmt.Sprintf("flash of device %s would overflow (%d of %d bytes)", e.ExtAddr, e.Used, e.Capacity)
+}
+
+type InvalidTimeOfDay struct {
+	Got string
+}
+
+func (e InvalidTimeOfDay) Error() string {
+	return fmt.Sprintf("invalid time of day %q! Expected HH:MM or HH:MM:SS", e.Got)
+}
+
+type InvalidWeekday struct {
+	Got time.Weekday
+}
+
+func (e InvalidWeekday) Error() string {
+	return fmt.Sprintf("invalid day of week %d! Expected 0 (Sunday) to 6 (Saturday)", int(e.Got))
+}
+
+type InvalidAclKey struct {
+	Key    AclKey
+	Reason string
+}
+
+func (e InvalidAclKey) Error() string {
+	return fmt.Sprintf("invalid acl key %v %q-%q: %s", e.Key.DaysOfWeek, e.Key.StartTime, e.Key.EndTime, e.Reason)
+}
+
+type InvalidTimeKey struct {
+	Key TimeKey
+}
+
+func (e InvalidTimeKey) Error() string {
+	return fmt.Sprintf("invalid time key %d-%d! Expected start before end", e.Key.StartTime, e.Key.EndTime)
+}
+
+type SweepError struct {
+	HashKeys []string
+}
+
+func (e SweepError) Error() string {
+	return fmt.Sprintf("removing expired time keys failed for hashKeys %q", e.HashKeys)
+}
+
+type UnsupportedSnapshotVersion struct {
+	Got int
+}
+
+func (e UnsupportedSnapshotVersion) Error() string {
+	return fmt.Sprintf("unsupported key snapshot version %d! Expected %d", e.Got, KeySnapshotVersion)
+}
+
+type RestoreError struct {
+	ExtAddr  string
+	HashKeys []string
+}
+
+func (e RestoreError) Error() string {
+	return fmt.Sprintf("restoring snapshot onto device %s left differences for hashKeys %q", e.ExtAddr, e.HashKeys)
+}
+
+type PolicySyntaxError struct {
+	Offset int
+	Token  string
+	Reason string
+}
+
+func (e PolicySyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("policy syntax error at end of input: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("policy syntax error at offset %d near %q: %s", e.Offset, e.Token, e.Reason)
+}
+
+type CalendarSyntaxError struct {
+	Line   int
+	Reason string
+}
+
+func (e CalendarSyntaxError) Error() string {
+	return fmt.Sprintf("calendar syntax error on line %d: %s", e.Line, e.Reason)
+}
+
+type CalendarRuleError struct {
+	Reason string
+}
+
+func (e CalendarRuleError) Error() string {
+	return e.Reason
+}
+
+type HolidayPushError struct {
+	HashKeys []string
+}
+
+func (e HolidayPushError) Error() string {
+	return fmt.Sprintf("holiday updates failed for hashKeys %q", e.HashKeys)
+}
+
+type FirmwareUpgradeError struct {
+	ExtAddr   string
+	Status    firmwareUpgradeStatus
+	ErrorCode int
+}
+
+func (e FirmwareUpgradeError) Error() string {
+	return fmt.Sprintf("firmware upgrade of device %s failed with status %s and error code %d: %s",
+		e.ExtAddr, e.Status, e.ErrorCode, e.Info().Description)
+}
+
+// Is matches a FirmwareUpgradeError target whose non-zero fields are all equal, the FirmwareErrorCode of the
+// response and ErrFirmwareRetryable when a retry may succeed.
+func (e FirmwareUpgradeError) Is(target error) bool {
+	switch t := target.(type) {
+	case FirmwareUpgradeError:
+		return (t.ExtAddr == "" || t.ExtAddr == e.ExtAddr) &&
+			(t.Status == "" || t.Status == e.Status) &&
+			(t.ErrorCode == 0 || t.ErrorCode == e.ErrorCode)
+	case FirmwareErrorCode:
+		return int(t) == e.ErrorCode
+	default:
+		return target == ErrFirmwareRetryable && e.Info().Retryable
+	}
+}
+
+type FirmwareStallError struct {
+	ExtAddr       string
+	BlockNr       int
+	TotalBlocksNr int
+	Timeout       time.Duration
+}
+
+func (e FirmwareStallError) Error() string {
+	return fmt.Sprintf("firmware upgrade of device %s stalled at block %d of %d for %s", e.ExtAddr, e.BlockNr, e.TotalBlocksNr, e.Timeout)
+}
+
+type FirmwareResumeError struct {
+	ExtAddr  string
+	FileName string
+	Reason   string
+}
+
+func (e FirmwareResumeError) Error() string {
+	return "cannot resume upgrade of device " + e.ExtAddr + " to " + e.FileName + ": " + e.Reason
+}
+
+type EventStreamClosedError struct {
+	ExtAddr string
+}
+
+func (e EventStreamClosedError) Error() string {
+	return "event stream of device " + e.ExtAddr + " closed"
+}
+
+type RolloutHaltedError struct {
+	Reason string
+}
+
+func (e RolloutHaltedError) Error() string {
+	return "firmware rollout halted: " + e.Reason
+}
+
+type RolloutWaveIncompleteError struct {
+	Wave    int
+	Devices []string
+}
+
+func (e RolloutWaveIncompleteError) Error() string {
+	return fmt.Sprintf("firmware rollout wave %d is incomplete, devices %q were not upgraded", e.Wave, e.Devices)
+}
+
+type InvalidFirmwareVersion struct {
+	Got string
+}
+
+func (e InvalidFirmwareVersion) Error() string {
+	return "invalid firmware version " + strconv.Quote(e.Got)
+}
+
+type InvalidFirmwareConstraint struct {
+	Got string
+}
+
+func (e InvalidFirmwareConstraint) Error() string {
+	return "invalid firmware constraint " + strconv.Quote(e.Got)
+}
+
+type IncompatibleFirmwareError struct {
+	DeviceType deviceType
+	Version    FirmwareVersion
+	Reason     string
+}
+
+func (e IncompatibleFirmwareError) Error() string {
+	return fmt.Sprintf("firmware %s is incompatible with %s: %s", e.Version, e.DeviceType, e.Reason)
+}
+
+type InvalidFirmwareImage struct {
+	Reason string
+}
+
+func (e InvalidFirmwareImage) Error() string { return "invalid firmware image: " + e.Reason }
+
+type InvalidFirmwareFileName struct {
+	Got string
+}
+
+func (e InvalidFirmwareFileName) Error() string {
+	return "invalid firmware fileName " + strconv.Quote(e.Got)
+}
+
+type FirmwareFileNotFound struct {
+	FileName string
+}
+
+func (e FirmwareFileNotFound) Error() string { return "firmware file " + e.FileName + " not found" }
+
+type FirmwareBlockCountError struct {
+	FileName string
+	Expected int
+	Got      int
+}
+
+func (e FirmwareBlockCountError) Error() string {
+	return fmt.Sprintf("firmware file %s has %d blocks, requester expects %d", e.FileName, e.Expected, e.Got)
+}
+
+type FirmwareBlockRangeError struct {
+	FileName      string
+	BlockNr       int
+	TotalBlocksNr int
+}
+
+func (e FirmwareBlockRangeError) Error() string {
+	return fmt.Sprintf("block %d of firmware file %s is out of range, it has %d blocks", e.BlockNr, e.FileName, e.TotalBlocksNr)
+}
+
+type PairUpgradeError struct {
+	ExtAddr string
+	Reason  string
+}
+
+func (e PairUpgradeError) Error() string {
+	return fmt.Sprintf("master/slave upgrade of device %s: %s", e.ExtAddr, e.Reason)
+}
+
+type FirmwareVerificationError struct {
+	ExtAddr  string
+	Expected FirmwareVersion
+	Got      string
+}
+
+func (e FirmwareVerificationError) Error() string {
+	return fmt.Sprintf("device %s reports firmware %q after upgrading to %s", e.ExtAddr, e.Got, e.Expected)
+}
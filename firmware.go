@@ -20,13 +20,15 @@ const (
 	UpgradeUnknownErrorStatus   firmwareUpgradeStatus = "unknownError"
 )
 
+// firmwareUpgradeStatus accepts the empty value, which stands for no status, so records of upgrades that never got
+// an answer encode and decode like any other.
 type firmwareUpgradeStatus string
 
 func (s *firmwareUpgradeStatus) UnmarshalJSON(bytes []byte) (err error) {
 	defer func() {
 		if s != nil {
 			switch *s {
-			case UpgradeSuccessStatus, UpgradeDeviceNotFoundStatus, UpgradeInvalidStateStatus,
+			case "", UpgradeSuccessStatus, UpgradeDeviceNotFoundStatus, UpgradeInvalidStateStatus,
 				UpgradeInvalidFileStatus, UpgradeInvalidFileIdStatus, UpgradeUnknownErrorStatus:
 			default:
 				err = InvalidFirmwareUpgradeStatus{*s}
@@ -41,7 +43,7 @@ func (s *firmwareUpgradeStatus) UnmarshalJSON(bytes []byte) (err error) {
 func (s *firmwareUpgradeStatus) MarshalJSON() ([]byte, error) {
 	if s != nil {
 		switch *s {
-		case UpgradeSuccessStatus, UpgradeDeviceNotFoundStatus, UpgradeInvalidStateStatus,
+		case "", UpgradeSuccessStatus, UpgradeDeviceNotFoundStatus, UpgradeInvalidStateStatus,
 			UpgradeInvalidFileStatus, UpgradeInvalidFileIdStatus, UpgradeUnknownErrorStatus:
 		default:
 			return nil, InvalidFirmwareUpgradeStatus{*s}
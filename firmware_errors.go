@@ -0,0 +1,117 @@
+package messages
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// Sentinels for errors.Is against the status of a failed upgrade.
+var (
+	ErrUpgradeDeviceNotFound = FirmwareUpgradeError{Status: UpgradeDeviceNotFoundStatus}
+	ErrUpgradeInvalidState   = FirmwareUpgradeError{Status: UpgradeInvalidStateStatus}
+	ErrUpgradeInvalidFile    = FirmwareUpgradeError{Status: UpgradeInvalidFileStatus}
+	ErrUpgradeInvalidFileId  = FirmwareUpgradeError{Status: UpgradeInvalidFileIdStatus}
+	ErrUpgradeUnknownError   = FirmwareUpgradeError{Status: UpgradeUnknownErrorStatus}
+
+	ErrFirmwareRetryable = errors.New("firmware upgrade may succeed when retried")
+)
+
+type FirmwareErrorInfo struct {
+	Description string `json:"description"`
+	Retryable   bool   `json:"retryable"`
+	Action      string `json:"action"`
+}
+
+var firmwareStatusInfo = map[firmwareUpgradeStatus]FirmwareErrorInfo{
+	UpgradeSuccessStatus: {
+		Description: "upgrade succeeded",
+	},
+	UpgradeDeviceNotFoundStatus: {
+		Description: "the device is not known to the gateway",
+		Retryable:   true,
+		Action:      "check that the device is paired and online, then retry",
+	},
+	UpgradeInvalidStateStatus: {
+		Description: "the device cannot start an upgrade in its current state",
+		Retryable:   true,
+		Action:      "abort any running upgrade and retry",
+	},
+	UpgradeInvalidFileStatus: {
+		Description: "the device rejected the firmware file",
+		Action:      "check that the image is built for the device type and intact",
+	},
+	UpgradeInvalidFileIdStatus: {
+		Description: "the firmware file is not known",
+		Action:      "check the fileName and that the file is served",
+	},
+	UpgradeUnknownErrorStatus: {
+		Description: "the device reported an unspecified error",
+		Retryable:   true,
+		Action:      "retry; report the error code if it persists",
+	},
+}
+
+// FirmwareErrorCode is the ErrorCode of a FirmwareVersionUpgradeResponse. The vendor's code table is not part of
+// the package, so applications register the codes they know with RegisterFirmwareErrorCode. Codes without an entry
+// keep their number and fall back to the description of the status.
+type FirmwareErrorCode int
+
+var firmwareErrorCodes = struct {
+	sync.RWMutex
+	info map[FirmwareErrorCode]FirmwareErrorInfo
+}{info: make(map[FirmwareErrorCode]FirmwareErrorInfo)}
+
+func RegisterFirmwareErrorCode(code FirmwareErrorCode, info FirmwareErrorInfo) {
+	firmwareErrorCodes.Lock()
+	defer firmwareErrorCodes.Unlock()
+
+	firmwareErrorCodes.info[code] = info
+}
+
+func (c FirmwareErrorCode) Info() (FirmwareErrorInfo, bool) {
+	firmwareErrorCodes.RLock()
+	defer firmwareErrorCodes.RUnlock()
+
+	info, ok := firmwareErrorCodes.info[c]
+	return info, ok
+}
+
+func (c FirmwareErrorCode) Error() string {
+	if info, ok := c.Info(); ok {
+		return "firmware error code " + strconv.Itoa(int(c)) + ": " + info.Description
+	}
+
+	return "unknown firmware error code " + strconv.Itoa(int(c))
+}
+
+func (s firmwareUpgradeStatus) Info() FirmwareErrorInfo {
+	if info, ok := firmwareStatusInfo[s]; ok {
+		return info
+	}
+
+	return FirmwareErrorInfo{Description: "unknown status " + string(s), Action: "report the status and error code"}
+}
+
+// Info describes the error by its code when the code is registered and by its status otherwise.
+func (e FirmwareUpgradeError) Info() FirmwareErrorInfo {
+	if info, ok := FirmwareErrorCode(e.ErrorCode).Info(); ok {
+		return info
+	}
+
+	info := e.Status.Info()
+
+	if e.ErrorCode != 0 {
+		info.Description += " (unknown error code " + strconv.Itoa(e.ErrorCode) + ")"
+	}
+
+	return info
+}
+
+func (r *FirmwareVersionUpgradeResponse) Err() error {
+	if r.Status == UpgradeSuccessStatus {
+		return nil
+	}
+
+	return FirmwareUpgradeError{ExtAddr: r.ExtAddr, Status: r.Status, ErrorCode: r.ErrorCode}
+}
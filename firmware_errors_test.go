@@ -0,0 +1,39 @@
+package messages
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFirmwareUpgradeErrorKeepsUnknownCode(t *testing.T) {
+	err := (&FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeUnknownErrorStatus, ErrorCode: 9001}).Err()
+
+	if !errors.Is(err, ErrUpgradeUnknownError) || !errors.Is(err, FirmwareErrorCode(9001)) {
+		t.Fatalf("%v does not match its status and code", err)
+	}
+
+	if !errors.Is(err, ErrFirmwareRetryable) {
+		t.Fatal("unknown error is not retryable")
+	}
+
+	if !strings.Contains(err.Error(), "9001") {
+		t.Fatalf("%q lost the error code", err)
+	}
+}
+
+func TestFirmwareUpgradeErrorUsesRegisteredCode(t *testing.T) {
+	RegisterFirmwareErrorCode(9002, FirmwareErrorInfo{Description: "flash write failed", Action: "replace the device"})
+
+	var upgradeErr FirmwareUpgradeError
+
+	err := (&FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeUnknownErrorStatus, ErrorCode: 9002}).Err()
+
+	if !errors.As(err, &upgradeErr) || upgradeErr.Info().Description != "flash write failed" {
+		t.Fatalf("%v is not described by its registered code", err)
+	}
+
+	if errors.Is(err, ErrFirmwareRetryable) {
+		t.Fatal("registered code that is not retryable matched ErrFirmwareRetryable")
+	}
+}
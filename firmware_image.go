@@ -0,0 +1,145 @@
+package messages
+
+import (
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+// FirmwareManifestSuffix names the manifest kept beside a firmware file: the manifest of "lock-2.3.0.bin" is
+// "lock-2.3.0.bin.manifest.json". The vendor does not document a header inside the file, so the metadata the
+// pre-flight check needs lives in the manifest and devices receive the file exactly as the vendor built it. Once
+// the header layout is known, a FirmwareHeaderParser reads the same metadata from the file itself.
+const FirmwareManifestSuffix = ".manifest.json"
+
+// FirmwareHeaderParser reads the manifest of a firmware file from the header the vendor put into it. The manifest
+// describes the whole file, which devices receive unchanged.
+type FirmwareHeaderParser func(file []byte) (FirmwareManifest, error)
+
+type FirmwareManifest struct {
+	DeviceType deviceType      `json:"deviceType"`
+	Version    FirmwareVersion `json:"version"`
+	Size       int64           `json:"size"`
+	Checksum   uint32          `json:"crc32"`
+}
+
+type FirmwareImage struct {
+	DeviceType deviceType
+	Version    FirmwareVersion
+	Checksum   uint32
+	Payload    []byte
+}
+
+func NewFirmwareImage(device deviceType, version FirmwareVersion, payload []byte) FirmwareImage {
+	return FirmwareImage{DeviceType: device, Version: version, Checksum: crc32.ChecksumIEEE(payload), Payload: payload}
+}
+
+// ReadFirmwareImage reads the firmware file at path together with its manifest.
+func ReadFirmwareImage(path string) (FirmwareImage, error) {
+	var manifest FirmwareManifest
+
+	found, err := readJSONFile(path+FirmwareManifestSuffix, &manifest)
+
+	if err != nil {
+		return FirmwareImage{}, err
+	}
+
+	if !found {
+		return FirmwareImage{}, InvalidFirmwareImage{"no manifest"}
+	}
+
+	payload, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return FirmwareImage{}, err
+	}
+
+	return ParseFirmwareImage(manifest, payload)
+}
+
+// ReadFirmwareImageHeader reads the firmware file at path and takes its manifest from the header parsed by header.
+func ReadFirmwareImageHeader(path string, header FirmwareHeaderParser) (FirmwareImage, error) {
+	file, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return FirmwareImage{}, err
+	}
+
+	manifest, err := header(file)
+
+	if err != nil {
+		return FirmwareImage{}, InvalidFirmwareImage{"header: " + err.Error()}
+	}
+
+	return ParseFirmwareImage(manifest, file)
+}
+
+// ParseFirmwareImage checks payload against its manifest.
+func ParseFirmwareImage(manifest FirmwareManifest, payload []byte) (FirmwareImage, error) {
+	image := FirmwareImage{DeviceType: manifest.DeviceType, Version: manifest.Version, Checksum: manifest.Checksum, Payload: payload}
+
+	if manifest.DeviceType == "" {
+		return image, InvalidFirmwareImage{"manifest has no device type"}
+	}
+
+	if manifest.Version.Components == 0 {
+		return image, InvalidFirmwareImage{"manifest has no version"}
+	}
+
+	if int64(len(payload)) != manifest.Size {
+		return image, InvalidFirmwareImage{"size does not match manifest"}
+	}
+
+	if crc32.ChecksumIEEE(payload) != manifest.Checksum {
+		return image, InvalidFirmwareImage{"checksum mismatch"}
+	}
+
+	return image, nil
+}
+
+func (i *FirmwareImage) Manifest() FirmwareManifest {
+	return FirmwareManifest{DeviceType: i.DeviceType, Version: i.Version, Size: int64(len(i.Payload)), Checksum: i.Checksum}
+}
+
+// WriteFirmwareImage writes the payload unchanged to path and the manifest beside it. The manifest is written
+// last, so a file without one was not stored completely.
+func WriteFirmwareImage(path string, image FirmwareImage) error {
+	if err := writeFileAtomic(path, image.Payload, 0640); err != nil {
+		return err
+	}
+
+	if err := writeJSONFile(path+FirmwareManifestSuffix, image.Manifest()); err != nil {
+		os.Remove(path + FirmwareManifestSuffix)
+		return err
+	}
+
+	return nil
+}
+
+// Preflight checks the image against the device before any block is sent. Without a table the image must be
+// newer than the installed firmware.
+func (i *FirmwareImage) Preflight(config *ConfigResponse, installed *FirmwareVersionResponse, table FirmwareCompatibilityTable) error {
+	if config.DeviceType == nil {
+		return IncompatibleFirmwareError{DeviceType: i.DeviceType, Version: i.Version, Reason: "device type of " + config.ExtAddr + " was not read"}
+	}
+
+	if *config.DeviceType != i.DeviceType {
+		return IncompatibleFirmwareError{DeviceType: *config.DeviceType, Version: i.Version, Reason: "image is built for " + string(i.DeviceType)}
+	}
+
+	current, err := installed.Version()
+
+	if err != nil {
+		return err
+	}
+
+	if table != nil {
+		return table.CheckUpgrade(i.DeviceType, current, i.Version)
+	}
+
+	if !current.Less(i.Version) {
+		return IncompatibleFirmwareError{DeviceType: i.DeviceType, Version: i.Version, Reason: "not newer than installed " + current.String()}
+	}
+
+	return nil
+}
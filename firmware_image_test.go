@@ -0,0 +1,116 @@
+package messages
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFirmwareImageKeepsFileUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock.bin")
+	payload := []byte("vendor firmware as built")
+
+	version, _ := ParseFirmwareVersion("2.3.0")
+	image := NewFirmwareImage(DeviceTypeFCLock, version, payload)
+
+	if err := WriteFirmwareImage(path, image); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(file, payload) {
+		t.Fatalf("file sent to devices is %q, want the payload unchanged", file)
+	}
+
+	read, err := ReadFirmwareImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read.DeviceType != DeviceTypeFCLock || read.Version.String() != "2.3.0" || !bytes.Equal(read.Payload, payload) {
+		t.Fatalf("read %+v", read)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("vendor firmware as bu1lt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ReadFirmwareImage(path); err == nil {
+		t.Fatal("a changed file passed the manifest check")
+	}
+}
+
+func TestFirmwareImageRequiresManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock.bin")
+
+	if err := ioutil.WriteFile(path, []byte("firmware"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadFirmwareImage(path); err == nil {
+		t.Fatal("read a firmware file without a manifest")
+	}
+}
+
+func TestFirmwareImagePreflight(t *testing.T) {
+	version, _ := ParseFirmwareVersion("2.3.0")
+	image := NewFirmwareImage(DeviceTypeFCLock, version, []byte("firmware"))
+
+	lock, relay := DeviceTypeFCLock, DeviceTypeFCRelay
+
+	if err := image.Preflight(&ConfigResponse{DeviceType: &lock}, &FirmwareVersionResponse{FwVersion: "2.2.0"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := image.Preflight(&ConfigResponse{DeviceType: &relay}, &FirmwareVersionResponse{FwVersion: "2.2.0"}, nil); err == nil {
+		t.Fatal("accepted an image built for another device type")
+	}
+
+	if err := image.Preflight(&ConfigResponse{DeviceType: &lock}, &FirmwareVersionResponse{FwVersion: "2.3.0"}, nil); err == nil {
+		t.Fatal("accepted an image that is not newer than the installed firmware")
+	}
+}
+
+func TestFirmwareImageFromHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock.bin")
+	file := []byte("LOCK firmware")
+
+	if err := ioutil.WriteFile(path, file, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	version, _ := ParseFirmwareVersion("2.3.0")
+
+	// A stand-in for the vendor header, which names the device type in the first four bytes.
+	header := func(file []byte) (FirmwareManifest, error) {
+		if !bytes.HasPrefix(file, []byte("LOCK")) {
+			return FirmwareManifest{}, InvalidFirmwareImage{"unknown device"}
+		}
+
+		image := NewFirmwareImage(DeviceTypeFCLock, version, file)
+
+		return image.Manifest(), nil
+	}
+
+	image, err := ReadFirmwareImageHeader(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.DeviceType != DeviceTypeFCLock || !bytes.Equal(image.Payload, file) {
+		t.Fatalf("read %+v", image)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("RELAY firmware"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ReadFirmwareImageHeader(path, header); err == nil {
+		t.Fatal("read a file whose header did not parse")
+	}
+}
@@ -0,0 +1,358 @@
+package messages
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	FirmwareVersionRecord    firmwareRecordKind = "version"
+	FirmwareDeviceTypeRecord firmwareRecordKind = "deviceType"
+	FirmwareUpgradeRecord    firmwareRecordKind = "upgrade"
+)
+
+type firmwareRecordKind string
+
+type FirmwareRecord struct {
+	Kind       firmwareRecordKind    `json:"kind"`
+	Time       time.Time             `json:"time"`
+	ExtAddr    string                `json:"extAddr"`
+	Gateway    string                `json:"gateway,omitempty"`
+	DeviceType deviceType            `json:"deviceType,omitempty"`
+	FwVersion  string                `json:"fwVersion,omitempty"`
+	FileName   string                `json:"fileName,omitempty"`
+	Status     firmwareUpgradeStatus `json:"status,omitempty"`
+	ErrorCode  int                   `json:"errorCode,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+// FirmwareHistoryStore persists firmware records. Implementations must scan records in the order they were appended.
+type FirmwareHistoryStore interface {
+	Append(record FirmwareRecord) error
+	Scan(fn func(record FirmwareRecord) bool) error
+}
+
+// FirmwareDevice is the latest known firmware state of a device.
+type FirmwareDevice struct {
+	ExtAddr         string                `json:"extAddr"`
+	Gateway         string                `json:"gateway,omitempty"`
+	DeviceType      deviceType            `json:"deviceType,omitempty"`
+	FwVersion       string                `json:"fwVersion,omitempty"`
+	VersionAt       time.Time             `json:"versionAt"`
+	UpgradeFileName string                `json:"upgradeFileName,omitempty"`
+	UpgradeStatus   firmwareUpgradeStatus `json:"upgradeStatus,omitempty"`
+	UpgradeError    string                `json:"upgradeError,omitempty"`
+	UpgradedAt      time.Time             `json:"upgradedAt"`
+}
+
+func (d *FirmwareDevice) apply(r *FirmwareRecord) {
+	if r.Gateway != "" {
+		d.Gateway = r.Gateway
+	}
+
+	switch r.Kind {
+	case FirmwareVersionRecord:
+		d.FwVersion, d.VersionAt = r.FwVersion, r.Time
+	case FirmwareDeviceTypeRecord:
+		d.DeviceType = r.DeviceType
+	case FirmwareUpgradeRecord:
+		d.UpgradeFileName, d.UpgradeStatus, d.UpgradeError, d.UpgradedAt = r.FileName, r.Status, r.Error, r.Time
+	}
+}
+
+// FirmwareInventoryQuery selects devices matching every non-zero field.
+type FirmwareInventoryQuery struct {
+	Versions   FirmwareConstraint
+	DeviceType deviceType
+	Gateway    string
+}
+
+// Match reports whether d matches q. A device with a version that does not parse only matches queries without
+// Versions.
+func (q *FirmwareInventoryQuery) Match(d *FirmwareDevice) bool {
+	if q.DeviceType != "" && q.DeviceType != d.DeviceType {
+		return false
+	}
+
+	if q.Gateway != "" && q.Gateway != d.Gateway {
+		return false
+	}
+
+	if len(q.Versions.terms) == 0 {
+		return true
+	}
+
+	v, err := ParseFirmwareVersion(d.FwVersion)
+
+	return err == nil && q.Versions.Matches(v)
+}
+
+// FirmwareInventory keeps the latest firmware state of every device and writes each observation to Store as
+// history. Call Load once to rebuild the state from the history before recording.
+type FirmwareInventory struct {
+	Store     FirmwareHistoryStore
+	Directory *NetworkDirectory
+	Now       func() time.Time
+
+	mu      sync.RWMutex
+	devices map[string]*FirmwareDevice
+}
+
+func (i *FirmwareInventory) now() time.Time {
+	if i.Now != nil {
+		return i.Now()
+	}
+
+	return time.Now()
+}
+
+func (i *FirmwareInventory) Load() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.devices = make(map[string]*FirmwareDevice)
+
+	return i.Store.Scan(func(r FirmwareRecord) bool {
+		i.apply(&r)
+		return true
+	})
+}
+
+func (i *FirmwareInventory) apply(r *FirmwareRecord) {
+	if i.devices == nil {
+		i.devices = make(map[string]*FirmwareDevice)
+	}
+
+	d := i.devices[r.ExtAddr]
+
+	if d == nil {
+		d = &FirmwareDevice{ExtAddr: r.ExtAddr}
+		i.devices[r.ExtAddr] = d
+	}
+
+	d.apply(r)
+}
+
+func (i *FirmwareInventory) record(r FirmwareRecord) error {
+	r.Time = i.now()
+
+	if i.Directory != nil {
+		r.Gateway, _ = i.Directory.Gateway(r.ExtAddr)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.Store.Append(r); err != nil {
+		return err
+	}
+
+	i.apply(&r)
+
+	return nil
+}
+
+func (i *FirmwareInventory) RecordVersion(rsp *FirmwareVersionResponse) error {
+	return i.record(FirmwareRecord{Kind: FirmwareVersionRecord, ExtAddr: rsp.ExtAddr, FwVersion: rsp.FwVersion})
+}
+
+// RecordConfig records the device type of a ConfigResponse that reports one.
+func (i *FirmwareInventory) RecordConfig(config *ConfigResponse) error {
+	if config.DeviceType == nil {
+		return nil
+	}
+
+	return i.record(FirmwareRecord{Kind: FirmwareDeviceTypeRecord, ExtAddr: config.ExtAddr, DeviceType: *config.DeviceType})
+}
+
+// RecordUpgrade records the outcome of an upgrade, err being the error the upgrade returned.
+func (i *FirmwareInventory) RecordUpgrade(result FirmwareUpgradeResult, err error) error {
+	r := FirmwareRecord{
+		Kind:      FirmwareUpgradeRecord,
+		ExtAddr:   result.ExtAddr,
+		FileName:  result.FileName,
+		Status:    result.Status,
+		ErrorCode: result.ErrorCode,
+	}
+
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	return i.record(r)
+}
+
+func (i *FirmwareInventory) Device(extAddr string) (FirmwareDevice, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	d, ok := i.devices[extAddr]
+
+	if !ok {
+		return FirmwareDevice{}, false
+	}
+
+	return i.current(d), true
+}
+
+// current returns d with the gateway the device is attached to now, when the Directory knows it.
+func (i *FirmwareInventory) current(d *FirmwareDevice) FirmwareDevice {
+	device := *d
+
+	if i.Directory != nil {
+		if gateway, ok := i.Directory.Gateway(d.ExtAddr); ok {
+			device.Gateway = gateway
+		}
+	}
+
+	return device
+}
+
+func (i *FirmwareInventory) Query(q FirmwareInventoryQuery) []FirmwareDevice {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var devices []FirmwareDevice
+
+	for _, d := range i.devices {
+		if device := i.current(d); q.Match(&device) {
+			devices = append(devices, device)
+		}
+	}
+
+	sort.Slice(devices, func(a, b int) bool { return devices[a].ExtAddr < devices[b].ExtAddr })
+
+	return devices
+}
+
+// History returns the records of a device, oldest first.
+func (i *FirmwareInventory) History(extAddr string) ([]FirmwareRecord, error) {
+	var records []FirmwareRecord
+
+	err := i.Store.Scan(func(r FirmwareRecord) bool {
+		if r.ExtAddr == extAddr {
+			records = append(records, r)
+		}
+
+		return true
+	})
+
+	return records, err
+}
+
+type FirmwareVersionCount struct {
+	DeviceType deviceType `json:"deviceType,omitempty"`
+	FwVersion  string     `json:"fwVersion"`
+	Devices    int        `json:"devices"`
+}
+
+type FirmwareReport struct {
+	GeneratedAt time.Time              `json:"generatedAt"`
+	Devices     []FirmwareDevice       `json:"devices"`
+	Versions    []FirmwareVersionCount `json:"versions"`
+}
+
+func (i *FirmwareInventory) Report(q FirmwareInventoryQuery) FirmwareReport {
+	report := FirmwareReport{GeneratedAt: i.now(), Devices: i.Query(q)}
+	counts := make(map[FirmwareVersionCount]int)
+
+	for _, d := range report.Devices {
+		counts[FirmwareVersionCount{DeviceType: d.DeviceType, FwVersion: d.FwVersion}]++
+	}
+
+	for c, n := range counts {
+		c.Devices = n
+		report.Versions = append(report.Versions, c)
+	}
+
+	sort.Slice(report.Versions, func(a, b int) bool {
+		if report.Versions[a].DeviceType != report.Versions[b].DeviceType {
+			return report.Versions[a].DeviceType < report.Versions[b].DeviceType
+		}
+
+		return report.Versions[a].FwVersion < report.Versions[b].FwVersion
+	})
+
+	return report
+}
+
+func (r *FirmwareReport) WriteJSON(w io.Writer) error {
+	bytes, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bytes)
+
+	return err
+}
+
+// WriteCSV writes one row per device after a header row.
+func (r *FirmwareReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+
+	_ = writer.Write([]string{
+		"ext_addr", "gateway", "device_type", "fw_version", "version_at",
+		"upgrade_file_name", "upgrade_status", "upgrade_error", "upgraded_at",
+	})
+
+	for _, d := range r.Devices {
+		_ = writer.Write([]string{
+			d.ExtAddr, d.Gateway, string(d.DeviceType), d.FwVersion, csvTime(d.VersionAt),
+			d.UpgradeFileName, string(d.UpgradeStatus), d.UpgradeError, csvTime(d.UpgradedAt),
+		})
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// FileFirmwareHistoryStore keeps the history as newline delimited JSON in a single append-only file.
+type FileFirmwareHistoryStore struct {
+	lines *lineFile
+}
+
+func OpenFileFirmwareHistoryStore(path string) (*FileFirmwareHistoryStore, error) {
+	lines, err := openLineFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileFirmwareHistoryStore{lines: lines}, nil
+}
+
+func (s *FileFirmwareHistoryStore) Append(record FirmwareRecord) error {
+	return s.lines.append(record)
+}
+
+func (s *FileFirmwareHistoryStore) Scan(fn func(record FirmwareRecord) bool) error {
+	return s.lines.scan(func(line []byte) (bool, error) {
+		var record FirmwareRecord
+
+		if err := json.Unmarshal(line, &record); err != nil {
+			return false, err
+		}
+
+		return fn(record), nil
+	})
+}
+
+func (s *FileFirmwareHistoryStore) Close() error {
+	return s.lines.close()
+}
@@ -0,0 +1,156 @@
+package messages
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFirmwareInventoryReloadsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "firmware.jsonl")
+
+	store, err := OpenFileFirmwareHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := &FirmwareInventory{Store: store}
+
+	if err = inventory.RecordVersion(&FirmwareVersionResponse{ExtAddr: "a", FwVersion: "2.2.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stalled := FirmwareStallError{ExtAddr: "a", BlockNr: 3, TotalBlocksNr: 10, Timeout: time.Minute}
+
+	if err = inventory.RecordUpgrade(FirmwareUpgradeResult{ExtAddr: "a", FileName: "fw.bin"}, stalled); err != nil {
+		t.Fatal(err)
+	}
+
+	var report bytes.Buffer
+
+	// Neither the device type nor the upgrade status of the device is known.
+	r := inventory.Report(FirmwareInventoryQuery{})
+	if err = r.WriteJSON(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Close()
+
+	if store, err = OpenFileFirmwareHistoryStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	defer store.Close()
+
+	inventory = &FirmwareInventory{Store: store}
+
+	if err = inventory.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := inventory.Device("a")
+	if !ok || d.FwVersion != "2.2.0" || d.UpgradeFileName != "fw.bin" || d.UpgradeError != stalled.Error() {
+		t.Fatalf("reloaded %+v, %v", d, ok)
+	}
+}
+
+// memoryHistory is a FirmwareHistoryStore in memory.
+type memoryHistory []FirmwareRecord
+
+func (h *memoryHistory) Append(record FirmwareRecord) error {
+	*h = append(*h, record)
+	return nil
+}
+
+func (h *memoryHistory) Scan(fn func(record FirmwareRecord) bool) error {
+	for _, r := range *h {
+		if !fn(r) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func inventoryFixture(t *testing.T) *FirmwareInventory {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	history := &memoryHistory{
+		{Kind: FirmwareDeviceTypeRecord, ExtAddr: "a", Gateway: "gw1", DeviceType: DeviceTypeFCLock},
+		{Kind: FirmwareVersionRecord, ExtAddr: "a", FwVersion: "2.2.0", Time: at},
+		{Kind: FirmwareDeviceTypeRecord, ExtAddr: "b", Gateway: "gw1", DeviceType: DeviceTypeFCLock},
+		{Kind: FirmwareVersionRecord, ExtAddr: "b", FwVersion: "2.2.0", Time: at},
+		{Kind: FirmwareDeviceTypeRecord, ExtAddr: "c", Gateway: "gw2", DeviceType: DeviceTypeFCRelay},
+		{Kind: FirmwareVersionRecord, ExtAddr: "c", FwVersion: "1.0.0", Time: at},
+		{Kind: FirmwareVersionRecord, ExtAddr: "d", Gateway: "gw2", FwVersion: "unreleased", Time: at},
+		{Kind: FirmwareVersionRecord, ExtAddr: "b", FwVersion: "2.3.0", Time: at.Add(time.Hour)},
+		{Kind: FirmwareUpgradeRecord, ExtAddr: "b", FileName: "fw.bin", Status: UpgradeSuccessStatus, Time: at.Add(time.Hour)},
+	}
+
+	inventory := &FirmwareInventory{Store: history, Now: func() time.Time { return at.Add(2 * time.Hour) }}
+
+	if err := inventory.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	return inventory
+}
+
+func TestFirmwareInventoryQuery(t *testing.T) {
+	inventory := inventoryFixture(t)
+
+	tests := []struct {
+		query   FirmwareInventoryQuery
+		devices []string
+	}{
+		{FirmwareInventoryQuery{}, []string{"a", "b", "c", "d"}},
+		{FirmwareInventoryQuery{Versions: MustParseFirmwareConstraint("< 2.3")}, []string{"a", "c"}},
+		{FirmwareInventoryQuery{DeviceType: DeviceTypeFCLock}, []string{"a", "b"}},
+		{FirmwareInventoryQuery{Gateway: "gw2"}, []string{"c", "d"}},
+		{FirmwareInventoryQuery{Versions: MustParseFirmwareConstraint(">= 2"), Gateway: "gw1"}, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		var devices []string
+		for _, d := range inventory.Query(tt.query) {
+			devices = append(devices, d.ExtAddr)
+		}
+
+		if !reflect.DeepEqual(devices, tt.devices) {
+			t.Errorf("query %+v = %v, want %v", tt.query, devices, tt.devices)
+		}
+	}
+}
+
+func TestFirmwareInventoryReport(t *testing.T) {
+	report := inventoryFixture(t).Report(FirmwareInventoryQuery{})
+
+	versions := []FirmwareVersionCount{
+		{FwVersion: "unreleased", Devices: 1},
+		{DeviceType: DeviceTypeFCLock, FwVersion: "2.2.0", Devices: 1},
+		{DeviceType: DeviceTypeFCLock, FwVersion: "2.3.0", Devices: 1},
+		{DeviceType: DeviceTypeFCRelay, FwVersion: "1.0.0", Devices: 1},
+	}
+
+	if !reflect.DeepEqual(report.Versions, versions) {
+		t.Fatalf("versions %+v", report.Versions)
+	}
+
+	var csv bytes.Buffer
+	if err := report.WriteCSV(&csv); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "ext_addr,gateway,device_type,fw_version") {
+		t.Fatalf("csv:\n%s", csv.String())
+	}
+
+	if want := "b,gw1,FullCloudLock,2.3.0,2024-03-01T13:00:00Z,fw.bin,success,,2024-03-01T13:00:00Z"; lines[2] != want {
+		t.Fatalf("row of b = %q, want %q", lines[2], want)
+	}
+}
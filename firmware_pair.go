@@ -0,0 +1,178 @@
+package messages
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type FirmwareTarget struct {
+	FileName string          `json:"fileName"`
+	Version  FirmwareVersion `json:"version"`
+}
+
+type PairUpgradeResult struct {
+	Master         string                 `json:"master"`
+	Slave          string                 `json:"slave,omitempty"`
+	Role           deviceRole             `json:"role,omitempty"`
+	SlaveFwAddress uint                   `json:"slaveFwAddress,omitempty"`
+	MasterResult   *FirmwareUpgradeResult `json:"masterResult,omitempty"`
+	SlaveResult    *FirmwareUpgradeResult `json:"slaveResult,omitempty"`
+	MasterVersion  string                 `json:"masterVersion,omitempty"`
+	SlaveVersion   string                 `json:"slaveVersion,omitempty"`
+}
+
+// PairUpgrader upgrades a master together with its slave: the slave first, as the master is its way into the
+// network, then the master, and verifies both versions afterwards. Slave resolves the slaveFwAddress the master
+// reports to the address of the slave, which depends on the installation. Standalone devices are upgraded on
+// their own. A device already on its target version is not upgraded again.
+type PairUpgrader struct {
+	Transport Transport
+	Upgrader  *FirmwareUpgrader
+	Slave     func(master string, slaveFwAddress uint) (string, error)
+
+	// The devices restart after an upgrade; versions are read VerifyAttempts times, VerifyInterval apart.
+	VerifyAttempts int
+	VerifyInterval time.Duration
+
+	transactionId uint32
+}
+
+func (p *PairUpgrader) nextTransactionId() uint32 { return atomic.AddUint32(&p.transactionId, 1) }
+
+func (p *PairUpgrader) Upgrade(ctx context.Context, master string, masterTarget, slaveTarget FirmwareTarget) (PairUpgradeResult, error) {
+	result := PairUpgradeResult{Master: master}
+
+	config, err := p.readConfig(ctx, master, "deviceRole", "slaveFwAddress")
+
+	if err != nil {
+		return result, err
+	}
+
+	if config.DeviceRole != nil {
+		result.Role = *config.DeviceRole
+	}
+
+	switch result.Role {
+	case DeviceRoleSlave:
+		return result, PairUpgradeError{ExtAddr: master, Reason: "device is a slave, upgrade its master instead"}
+	case DeviceRoleMaster:
+		if config.SlaveFwAddress == nil {
+			return result, PairUpgradeError{ExtAddr: master, Reason: "master did not report slaveFwAddress"}
+		}
+
+		result.SlaveFwAddress = *config.SlaveFwAddress
+
+		if p.Slave == nil {
+			return result, PairUpgradeError{ExtAddr: master, Reason: "no resolver for slaveFwAddress"}
+		}
+
+		if result.Slave, err = p.Slave(master, result.SlaveFwAddress); err != nil {
+			return result, err
+		}
+
+		slaveConfig, err := p.readConfig(ctx, result.Slave, "deviceRole")
+
+		if err != nil {
+			return result, err
+		}
+
+		if slaveConfig.DeviceRole == nil || *slaveConfig.DeviceRole != DeviceRoleSlave {
+			return result, PairUpgradeError{ExtAddr: result.Slave, Reason: "resolved slave does not report the slave role"}
+		}
+
+		if result.SlaveResult, err = p.upgrade(ctx, result.Slave, slaveTarget); err != nil {
+			return result, err
+		}
+	}
+
+	if result.MasterResult, err = p.upgrade(ctx, master, masterTarget); err != nil {
+		return result, err
+	}
+
+	if result.MasterVersion, err = p.verify(ctx, master, masterTarget.Version); err != nil {
+		return result, err
+	}
+
+	if result.Slave != "" {
+		if result.SlaveVersion, err = p.verify(ctx, result.Slave, slaveTarget.Version); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func (p *PairUpgrader) readConfig(ctx context.Context, extAddr string, keys ...string) (*ConfigResponse, error) {
+	request := (&ReadConfig{TransactionId: p.nextTransactionId()}).InitFromKeys(keys)
+
+	var config ConfigResponse
+
+	if err := p.Transport.Request(ctx, extAddr, request, &config); err != nil {
+		return nil, err
+	}
+
+	if config.Status != ResponseStatusReadOK {
+		return nil, PairUpgradeError{ExtAddr: extAddr, Reason: "reading config failed with status " + string(config.Status)}
+	}
+
+	return &config, nil
+}
+
+func (p *PairUpgrader) version(ctx context.Context, extAddr string) (FirmwareVersion, string, error) {
+	var rsp FirmwareVersionResponse
+
+	if err := p.Transport.Request(ctx, extAddr, &FirmwareVersionRequest{TransactionId: p.nextTransactionId()}, &rsp); err != nil {
+		return FirmwareVersion{}, "", err
+	}
+
+	v, err := rsp.Version()
+
+	return v, rsp.FwVersion, err
+}
+
+func (p *PairUpgrader) upgrade(ctx context.Context, extAddr string, target FirmwareTarget) (*FirmwareUpgradeResult, error) {
+	if current, _, err := p.version(ctx, extAddr); err == nil && current.Compare(target.Version) == 0 {
+		return nil, nil
+	}
+
+	result, err := p.Upgrader.Upgrade(ctx, extAddr, target.FileName, nil)
+
+	return &result, err
+}
+
+func (p *PairUpgrader) verify(ctx context.Context, extAddr string, expected FirmwareVersion) (string, error) {
+	attempts := p.VerifyAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var raw string
+	var err error
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			timer := time.NewTimer(p.VerifyInterval)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return raw, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		var v FirmwareVersion
+
+		// A device still starting may answer with its old version, so a mismatch is retried like a failed read.
+		if v, raw, err = p.version(ctx, extAddr); err == nil {
+			if v.Compare(expected) == 0 {
+				return raw, nil
+			}
+
+			err = FirmwareVerificationError{ExtAddr: extAddr, Expected: expected, Got: raw}
+		}
+	}
+
+	return raw, err
+}
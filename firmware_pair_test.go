@@ -0,0 +1,73 @@
+package messages
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+// fakeVersions is a Transport answering firmware version requests with versions, one per request, repeating the
+// last one.
+type fakeVersions struct {
+	versions []string
+	requests int
+}
+
+func (f *fakeVersions) Request(_ context.Context, extAddr string, _ json.Marshaler, response json.Unmarshaler) error {
+	i := f.requests
+	if i >= len(f.versions) {
+		i = len(f.versions) - 1
+	}
+
+	f.requests++
+
+	rsp := response.(*FirmwareVersionResponse)
+	rsp.ExtAddr, rsp.FwVersion = extAddr, f.versions[i]
+
+	return nil
+}
+
+func TestPairUpgraderVerifyWaitsForRestart(t *testing.T) {
+	transport := &fakeVersions{versions: []string{"2.2.0", "2.2.0", "2.3.0"}}
+	upgrader := &PairUpgrader{Transport: transport, VerifyAttempts: 3}
+
+	expected, _ := ParseFirmwareVersion("2.3.0")
+
+	if raw, err := upgrader.verify(context.Background(), "a", expected); err != nil || raw != "2.3.0" {
+		t.Fatalf("verified %q, %v", raw, err)
+	}
+
+	transport = &fakeVersions{versions: []string{"2.2.0"}}
+	upgrader.Transport = transport
+
+	if _, err := upgrader.verify(context.Background(), "a", expected); err == nil {
+		t.Fatal("verified a device that never reported the expected version")
+	}
+
+	if transport.requests != 3 {
+		t.Fatalf("read the version %d times, want every attempt", transport.requests)
+	}
+}
+
+func TestPairUpgradeResultMarshalsWithoutRole(t *testing.T) {
+	if _, err := json.Marshal(&PairUpgradeResult{Master: "a"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPairUpgraderVerifyReportsMismatch(t *testing.T) {
+	upgrader := &PairUpgrader{Transport: &fakeVersions{versions: []string{"2.3.0"}}}
+	expected, _ := ParseFirmwareVersion("2.3.0")
+
+	if raw, err := upgrader.verify(context.Background(), "a", expected); err != nil || raw != "2.3.0" {
+		t.Fatalf("verified %q, %v", raw, err)
+	}
+
+	upgrader.Transport = &fakeVersions{versions: []string{"2.2.0"}}
+
+	_, err := upgrader.verify(context.Background(), "a", expected)
+	if mismatch, ok := err.(FirmwareVerificationError); !ok || mismatch.Got != "2.2.0" {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type FirmwareCheckpoint struct {
+	ExtAddr       string    `json:"extAddr"`
+	FileName      string    `json:"fileName"`
+	BlockNr       int       `json:"blockNr"`
+	TotalBlocksNr int       `json:"totalBlocksNr"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// FirmwareCheckpointStore remembers the last block a device confirmed for a firmware file.
+type FirmwareCheckpointStore interface {
+	Load(extAddr, fileName string) (FirmwareCheckpoint, bool, error)
+	Save(checkpoint FirmwareCheckpoint) error
+	Clear(extAddr, fileName string) error
+}
+
+// FileCheckpointStore keeps all checkpoints in one JSON file at Path, rewritten on every change.
+type FileCheckpointStore struct {
+	Path string
+
+	records recordFile
+}
+
+func (s *FileCheckpointStore) Load(extAddr, fileName string) (FirmwareCheckpoint, bool, error) {
+	var checkpoint FirmwareCheckpoint
+	found := false
+
+	err := s.records.load(s.Path, func(record []byte) error {
+		var c FirmwareCheckpoint
+
+		if err := json.Unmarshal(record, &c); err != nil {
+			return err
+		}
+
+		if c.ExtAddr == extAddr && c.FileName == fileName {
+			checkpoint, found = c, true
+		}
+
+		return nil
+	})
+
+	return checkpoint, found, err
+}
+
+func (s *FileCheckpointStore) Save(checkpoint FirmwareCheckpoint) error {
+	return s.records.put(s.Path, checkpoint.ExtAddr+"/"+checkpoint.FileName, checkpoint)
+}
+
+func (s *FileCheckpointStore) Clear(extAddr, fileName string) error {
+	return s.records.delete(s.Path, extAddr+"/"+fileName)
+}
+
+// DefaultResumeProbe is used when a ResumableUpgrader has no Probe.
+const DefaultResumeProbe = time.Minute
+
+// ResumableUpgrader continues interrupted upgrades from the last block the device confirmed. Once a checkpoint is
+// saved, it leaves the transfer on the device when ctx ends or the transfer stalls; before that there is nothing
+// to resume from, and the transfer is aborted like any other upgrade. The device fetches the
+// blocks itself, so a transfer is resumed by following it once the device reports a block of it again, at or past
+// the checkpoint, within Probe. A device that does not is told to abort, and the upgrade starts over from block 0.
+type ResumableUpgrader struct {
+	Upgrader    *FirmwareUpgrader
+	Checkpoints FirmwareCheckpointStore
+	Probe       time.Duration
+}
+
+func (r *ResumableUpgrader) probe() time.Duration {
+	if r.Probe > 0 {
+		return r.Probe
+	}
+
+	return DefaultResumeProbe
+}
+
+func (r *ResumableUpgrader) Upgrade(ctx context.Context, extAddr, fileName string, progress chan<- FirmwareProgress) (FirmwareUpgradeResult, error) {
+	checkpoint, ok, err := r.Checkpoints.Load(extAddr, fileName)
+
+	if err != nil {
+		return FirmwareUpgradeResult{ExtAddr: extAddr, FileName: fileName}, err
+	}
+
+	var resume *FirmwareCheckpoint
+	if ok {
+		resume = &checkpoint
+	}
+
+	result, err := r.upgrade(ctx, extAddr, fileName, resume, progress)
+
+	var resumeErr FirmwareResumeError
+
+	if errors.As(err, &resumeErr) {
+		// The checkpoint goes before the abort, so no checkpoint outlives a transfer that was aborted.
+		if err = r.Checkpoints.Clear(extAddr, fileName); err != nil {
+			return result, err
+		}
+
+		if result = r.Upgrader.abort(result); !result.Aborted {
+			return result, resumeErr
+		}
+
+		result, err = r.upgrade(ctx, extAddr, fileName, nil, progress)
+	}
+
+	// Once the device answered, its transfer is over and there is nothing left to resume.
+	if err == nil || result.Status != "" {
+		if clearErr := r.Checkpoints.Clear(extAddr, fileName); err == nil {
+			err = clearErr
+		}
+	}
+
+	return result, err
+}
+
+func (r *ResumableUpgrader) upgrade(ctx context.Context, extAddr, fileName string, resume *FirmwareCheckpoint, progress chan<- FirmwareProgress) (FirmwareUpgradeResult, error) {
+	var saveErr error
+
+	saved := resume != nil
+
+	result, err := r.Upgrader.upgrade(ctx, extAddr, fileName, progress, upgradeRun{
+		resume: resume,
+		probe:  r.probe(),
+		keep:   func() bool { return saved },
+		observe: func(p FirmwareProgress) {
+			if saveErr == nil {
+				saveErr = r.Checkpoints.Save(FirmwareCheckpoint{
+					ExtAddr:       extAddr,
+					FileName:      fileName,
+					BlockNr:       p.BlockNr,
+					TotalBlocksNr: p.TotalBlocksNr,
+					UpdatedAt:     p.At,
+				})
+				saved = saved || saveErr == nil
+			}
+		},
+	})
+
+	if err == nil && saveErr != nil {
+		err = saveErr
+	}
+
+	return result, err
+}
@@ -0,0 +1,149 @@
+package messages
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func newResumableUpgrader(t *testing.T, stream *fakeEventStream) *ResumableUpgrader {
+	return &ResumableUpgrader{
+		Upgrader:    &FirmwareUpgrader{Stream: stream, StallTimeout: 50 * time.Millisecond},
+		Checkpoints: &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoints.json")},
+		Probe:       50 * time.Millisecond,
+	}
+}
+
+func TestResumableUpgraderFollowsTransferOnDevice(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := newResumableUpgrader(t, stream)
+
+	if err := upgrader.Checkpoints.Save(FirmwareCheckpoint{ExtAddr: "a", FileName: "fw.bin", BlockNr: 800, TotalBlocksNr: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: 801, TotalBlocksNr: 1000})
+	stream.push(t, &FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeSuccessStatus})
+
+	if _, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if upgrades, aborts := stream.requests(); upgrades != 0 || aborts != 0 {
+		t.Fatalf("resume sent %d upgrade and %d abort requests", upgrades, aborts)
+	}
+
+	if _, ok, _ := upgrader.Checkpoints.Load("a", "fw.bin"); ok {
+		t.Fatal("checkpoint kept after the upgrade succeeded")
+	}
+}
+
+func TestResumableUpgraderRestartsWhenDeviceLostTransfer(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := newResumableUpgrader(t, stream)
+
+	stream.onSend = func(request json.Marshaler) {
+		if _, ok := request.(*FirmwareVersionUpgradeRequest); ok {
+			stream.push(t, &FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeSuccessStatus})
+		}
+	}
+
+	if err := upgrader.Checkpoints.Save(FirmwareCheckpoint{ExtAddr: "a", FileName: "fw.bin", BlockNr: 800, TotalBlocksNr: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stream.mu.Lock()
+	sent := stream.sent
+	stream.mu.Unlock()
+
+	if len(sent) != 2 {
+		t.Fatalf("sent %d requests, want an abort and an upgrade", len(sent))
+	}
+
+	if _, ok := sent[0].(*FirmwareUpdateAbort); !ok {
+		t.Fatalf("first request is %T, want the abort", sent[0])
+	}
+
+	if _, ok := sent[1].(*FirmwareVersionUpgradeRequest); !ok {
+		t.Fatalf("second request is %T, want the upgrade", sent[1])
+	}
+}
+
+func TestResumableUpgraderKeepsStalledTransfer(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := newResumableUpgrader(t, stream)
+
+	stream.onSend = func(request json.Marshaler) {
+		if _, ok := request.(*FirmwareVersionUpgradeRequest); ok {
+			stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: 5, TotalBlocksNr: 10})
+		}
+	}
+
+	if _, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil); err == nil {
+		t.Fatal("stalled upgrade succeeded")
+	}
+
+	if _, aborts := stream.requests(); aborts != 0 {
+		t.Fatal("aborted a transfer whose checkpoint is kept")
+	}
+
+	checkpoint, ok, err := upgrader.Checkpoints.Load("a", "fw.bin")
+	if err != nil || !ok || checkpoint.BlockNr != 5 {
+		t.Fatalf("checkpoint %+v, %v, %v", checkpoint, ok, err)
+	}
+}
+
+func TestResumableUpgraderAbortsTransferWithoutCheckpoint(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := newResumableUpgrader(t, stream)
+
+	result, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil)
+
+	if _, ok := err.(FirmwareStallError); !ok {
+		t.Fatalf("err = %v", err)
+	}
+
+	if _, aborts := stream.requests(); aborts != 1 || !result.Aborted {
+		t.Fatalf("sent %d aborts for a transfer that stalled before its first block", aborts)
+	}
+
+	if _, ok, _ := upgrader.Checkpoints.Load("a", "fw.bin"); ok {
+		t.Fatal("checkpoint saved for a transfer without blocks")
+	}
+}
+
+func TestFileCheckpointStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	store := &FileCheckpointStore{Path: path}
+
+	for _, checkpoint := range []FirmwareCheckpoint{
+		{ExtAddr: "a", FileName: "fw.bin", BlockNr: 10, TotalBlocksNr: 100},
+		{ExtAddr: "b", FileName: "fw.bin", BlockNr: 20, TotalBlocksNr: 100},
+		{ExtAddr: "a", FileName: "fw.bin", BlockNr: 30, TotalBlocksNr: 100},
+	} {
+		if err := store.Save(checkpoint); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Clear("b", "fw.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &FileCheckpointStore{Path: path}
+
+	if checkpoint, ok, err := restarted.Load("a", "fw.bin"); err != nil || !ok || checkpoint.BlockNr != 30 {
+		t.Fatalf("checkpoint of a: %+v, %v, %v", checkpoint, ok, err)
+	}
+
+	if _, ok, err := restarted.Load("b", "fw.bin"); err != nil || ok {
+		t.Fatalf("cleared checkpoint of b: %v, %v", ok, err)
+	}
+}
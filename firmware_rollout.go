@@ -0,0 +1,371 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	RolloutPending   rolloutDeviceState = "pending"
+	RolloutRunning   rolloutDeviceState = "running"
+	RolloutSucceeded rolloutDeviceState = "succeeded"
+	RolloutFailed    rolloutDeviceState = "failed"
+	RolloutSkipped   rolloutDeviceState = "skipped"
+)
+
+type rolloutDeviceState string
+
+// RolloutPlan splits the devices into a canary of CanarySize devices and waves. Waves holds cumulative
+// percentages of the devices left after the canary, e.g. 10, 50, 100; a final 100 is implied.
+type RolloutPlan struct {
+	CanarySize int       `json:"canarySize"`
+	Waves      []float64 `json:"waves"`
+}
+
+type RolloutDevice struct {
+	ExtAddr        string                 `json:"extAddr"`
+	Gateway        string                 `json:"gateway"`
+	Wave           int                    `json:"wave"`
+	State          rolloutDeviceState     `json:"state"`
+	Result         *FirmwareUpgradeResult `json:"result,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	FailureCounted bool                   `json:"failureCounted,omitempty"`
+}
+
+// FirmwareRollout is the persistent state of a staged rollout. Wave 0 is the canary.
+type FirmwareRollout struct {
+	FileName   string          `json:"fileName"`
+	Plan       RolloutPlan     `json:"plan"`
+	Devices    []RolloutDevice `json:"devices"`
+	Halted     bool            `json:"halted"`
+	HaltReason string          `json:"haltReason,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// NewFirmwareRollout assigns devices to waves in address order and records the gateway each of them is on, when
+// directory tells it.
+func NewFirmwareRollout(fileName string, plan RolloutPlan, directory DeviceDirectory, devices []string) *FirmwareRollout {
+	sorted := append([]string(nil), devices...)
+	sort.Strings(sorted)
+
+	waves := append([]float64(nil), plan.Waves...)
+	if len(waves) == 0 || waves[len(waves)-1] < 100 {
+		waves = append(waves, 100)
+	}
+
+	canary := plan.CanarySize
+	if canary > len(sorted) {
+		canary = len(sorted)
+	}
+
+	rest := len(sorted) - canary
+	rollout := &FirmwareRollout{FileName: fileName, Plan: plan, CreatedAt: time.Now()}
+
+	for i, extAddr := range sorted {
+		d := RolloutDevice{ExtAddr: extAddr, State: RolloutPending}
+
+		if directory != nil {
+			d.Gateway = gateway(directory, extAddr)
+		}
+
+		if i >= canary {
+			for w, percent := range waves {
+				if float64(i-canary) < math.Ceil(percent/100*float64(rest)) {
+					d.Wave = w + 1
+					break
+				}
+			}
+		}
+
+		rollout.Devices = append(rollout.Devices, d)
+	}
+
+	return rollout
+}
+
+func LoadFirmwareRollout(path string) (*FirmwareRollout, error) {
+	bytes, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rollout FirmwareRollout
+
+	if err = json.Unmarshal(bytes, &rollout); err != nil {
+		return nil, err
+	}
+
+	return &rollout, nil
+}
+
+// Save writes the rollout to a temporary file first, so path always holds a complete state.
+func (r *FirmwareRollout) Save(path string) error {
+	bytes, err := json.MarshalIndent(r, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, bytes, 0600)
+}
+
+// Resume clears a halt, so the next run continues with the devices that are left.
+func (r *FirmwareRollout) Resume() {
+	r.Halted = false
+	r.HaltReason = ""
+}
+
+func (r *FirmwareRollout) Finished() int {
+	finished := 0
+
+	for _, d := range r.Devices {
+		if d.State == RolloutSucceeded || d.State == RolloutFailed {
+			finished++
+		}
+	}
+
+	return finished
+}
+
+func (r *FirmwareRollout) FailureRate() float64 {
+	failed := 0
+
+	for _, d := range r.Devices {
+		if d.FailureCounted {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		return 0
+	}
+
+	return float64(failed) / float64(r.Finished())
+}
+
+func (r *FirmwareRollout) Done() bool {
+	for _, d := range r.Devices {
+		if d.State != RolloutSucceeded && d.State != RolloutFailed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RolloutRunner upgrades the devices of a rollout wave by wave, at most PerGateway devices of a gateway at a
+// time. Invalid state, unknown errors, stalls and DeviceTimeout count as failures; once at least MinFinished
+// devices finished and the share of such failures passes MaxFailureRate, no further upgrades are started and
+// the rollout halts. A wave with devices that were skipped as offline or are otherwise left without a result stops
+// the run before the next wave, so a later wave never starts while an earlier one, above all the canary, is
+// incomplete; running the rollout again retries them. The state is saved to StatePath after every change.
+type RolloutRunner struct {
+	Upgrader       *FirmwareUpgrader
+	Directory      DeviceDirectory
+	PerGateway     int
+	DeviceTimeout  time.Duration
+	MaxFailureRate float64
+	MinFinished    int
+	StatePath      string
+	OnResult       func(RolloutDevice)
+
+	mu       sync.Mutex
+	gateways map[string]chan struct{}
+}
+
+func (r *RolloutRunner) Run(ctx context.Context, rollout *FirmwareRollout) error {
+	// Upgrades that were running when the previous run ended are started over.
+	for i := range rollout.Devices {
+		if rollout.Devices[i].State == RolloutRunning {
+			rollout.Devices[i].State = RolloutPending
+		}
+	}
+
+	waves := 0
+	for _, d := range rollout.Devices {
+		if d.Wave > waves {
+			waves = d.Wave
+		}
+	}
+
+	var saveErr error
+
+	for wave := 0; wave <= waves; wave++ {
+		if rollout.Halted {
+			return RolloutHaltedError{Reason: rollout.HaltReason}
+		}
+
+		var wg sync.WaitGroup
+
+		for i := range rollout.Devices {
+			d := &rollout.Devices[i]
+
+			if d.Wave != wave || d.State == RolloutSucceeded || d.State == RolloutFailed {
+				continue
+			}
+
+			wg.Add(1)
+
+			go func(d *RolloutDevice) {
+				defer wg.Done()
+
+				if err := r.upgrade(ctx, rollout, d); err != nil {
+					r.mu.Lock()
+					saveErr = err
+					r.mu.Unlock()
+				}
+			}(d)
+		}
+
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if saveErr != nil {
+			return saveErr
+		}
+
+		if rollout.Halted {
+			break
+		}
+
+		var incomplete []string
+		for _, d := range rollout.Devices {
+			if d.Wave == wave && d.State != RolloutSucceeded && d.State != RolloutFailed {
+				incomplete = append(incomplete, d.ExtAddr)
+			}
+		}
+
+		if len(incomplete) > 0 {
+			return RolloutWaveIncompleteError{Wave: wave, Devices: incomplete}
+		}
+	}
+
+	if rollout.Halted {
+		return RolloutHaltedError{Reason: rollout.HaltReason}
+	}
+
+	return nil
+}
+
+func (r *RolloutRunner) slot(gateway string) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.gateways == nil {
+		r.gateways = make(map[string]chan struct{})
+	}
+
+	if r.gateways[gateway] == nil {
+		limit := r.PerGateway
+		if limit <= 0 {
+			limit = 1
+		}
+
+		r.gateways[gateway] = make(chan struct{}, limit)
+	}
+
+	return r.gateways[gateway]
+}
+
+func (r *RolloutRunner) upgrade(ctx context.Context, rollout *FirmwareRollout, d *RolloutDevice) error {
+	slot := r.slot(d.Gateway)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case slot <- struct{}{}:
+	}
+
+	defer func() { <-slot }()
+
+	r.mu.Lock()
+
+	if rollout.Halted {
+		r.mu.Unlock()
+		return nil
+	}
+
+	if r.Directory != nil && !r.Directory.Online(d.ExtAddr) {
+		d.State = RolloutSkipped
+		r.mu.Unlock()
+		return r.save(rollout, *d)
+	}
+
+	d.State = RolloutRunning
+	d.Error, d.FailureCounted = "", false
+	r.mu.Unlock()
+
+	if err := r.save(rollout, *d); err != nil {
+		return err
+	}
+
+	deviceCtx := ctx
+	if r.DeviceTimeout > 0 {
+		var cancel context.CancelFunc
+		deviceCtx, cancel = context.WithTimeout(ctx, r.DeviceTimeout)
+		defer cancel()
+	}
+
+	result, err := r.Upgrader.Upgrade(deviceCtx, d.ExtAddr, rollout.FileName, nil)
+
+	r.mu.Lock()
+
+	switch {
+	case ctx.Err() != nil:
+		d.State = RolloutPending
+	case err != nil:
+		d.State = RolloutFailed
+		d.Result = &result
+		d.Error = err.Error()
+		d.FailureCounted = rolloutFailure(err)
+	default:
+		d.State = RolloutSucceeded
+		d.Result = &result
+	}
+
+	if rate := rollout.FailureRate(); !rollout.Halted && rollout.Finished() >= r.MinFinished && rate > r.MaxFailureRate {
+		rollout.Halted = true
+		rollout.HaltReason = fmt.Sprintf("failure rate %.2f exceeded %.2f after %d devices", rate, r.MaxFailureRate, rollout.Finished())
+	}
+
+	r.mu.Unlock()
+
+	return r.save(rollout, *d)
+}
+
+func (r *RolloutRunner) save(rollout *FirmwareRollout, d RolloutDevice) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rollout.UpdatedAt = time.Now()
+
+	if r.OnResult != nil && d.State != RolloutRunning {
+		r.OnResult(d)
+	}
+
+	if r.StatePath == "" {
+		return nil
+	}
+
+	return rollout.Save(r.StatePath)
+}
+
+func rolloutFailure(err error) bool {
+	var stallErr FirmwareStallError
+
+	return errors.Is(err, ErrUpgradeInvalidState) || errors.Is(err, ErrUpgradeUnknownError) ||
+		errors.As(err, &stallErr) || errors.Is(err, context.DeadlineExceeded)
+}
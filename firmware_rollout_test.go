@@ -0,0 +1,213 @@
+package messages
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// gatewayDirectory maps the online devices to their gateways.
+type gatewayDirectory map[string]string
+
+func (d gatewayDirectory) Devices() []string {
+	var devices []string
+	for extAddr := range d {
+		devices = append(devices, extAddr)
+	}
+
+	sort.Strings(devices)
+
+	return devices
+}
+
+func (d gatewayDirectory) Online(extAddr string) bool {
+	_, ok := d[extAddr]
+	return ok
+}
+
+func (d gatewayDirectory) Gateway(extAddr string) (string, bool) {
+	gateway, ok := d[extAddr]
+	return gateway, ok
+}
+
+// rolloutStream answers every upgrade request after a short while with the status set for the device, success by
+// default, and records how many upgrades ran at once.
+type rolloutStream struct {
+	mu       sync.Mutex
+	events   map[string]chan []byte
+	status   map[string]firmwareUpgradeStatus
+	upgraded []string
+	running  int
+	peak     int
+}
+
+func (s *rolloutStream) channel(extAddr string) chan []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.events == nil {
+		s.events = make(map[string]chan []byte)
+	}
+
+	if s.events[extAddr] == nil {
+		s.events[extAddr] = make(chan []byte, 1)
+	}
+
+	return s.events[extAddr]
+}
+
+func (s *rolloutStream) Events(_ context.Context, extAddr string) (<-chan []byte, error) {
+	return s.channel(extAddr), nil
+}
+
+func (s *rolloutStream) Send(_ context.Context, extAddr string, request json.Marshaler) error {
+	if _, ok := request.(*FirmwareVersionUpgradeRequest); !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.upgraded = append(s.upgraded, extAddr)
+	if s.running++; s.running > s.peak {
+		s.peak = s.running
+	}
+
+	status := s.status[extAddr]
+	if status == "" {
+		status = UpgradeSuccessStatus
+	}
+	s.mu.Unlock()
+
+	bytes, err := json.Marshal(&FirmwareVersionUpgradeResponse{ExtAddr: extAddr, Status: status})
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+
+		s.channel(extAddr) <- bytes
+	}()
+
+	return err
+}
+
+func TestNewFirmwareRolloutAssignsWaves(t *testing.T) {
+	var devices []string
+	directory := gatewayDirectory{}
+
+	for _, extAddr := range []string{"l", "k", "j", "i", "h", "g", "f", "e", "d", "c", "b", "a"} {
+		devices = append(devices, extAddr)
+		directory[extAddr] = "gw-" + extAddr
+	}
+
+	rollout := NewFirmwareRollout("fw.bin", RolloutPlan{CanarySize: 2, Waves: []float64{10, 50}}, directory, devices)
+
+	// Two canaries, then 10%, 50% and the implied 100% of the ten devices left.
+	waves := []int{0, 0, 1, 2, 2, 2, 2, 3, 3, 3, 3, 3}
+
+	for i, d := range rollout.Devices {
+		if d.ExtAddr != devices[len(devices)-1-i] || d.Wave != waves[i] || d.Gateway != "gw-"+d.ExtAddr || d.State != RolloutPending {
+			t.Errorf("device %d = %+v, want wave %d", i, d, waves[i])
+		}
+	}
+}
+
+func TestRolloutRunnerHaltsOnFailingCanary(t *testing.T) {
+	stream := &rolloutStream{status: map[string]firmwareUpgradeStatus{"a": UpgradeInvalidStateStatus}}
+	directory := gatewayDirectory{"a": "gw", "b": "gw", "c": "gw"}
+
+	rollout := NewFirmwareRollout("fw.bin", RolloutPlan{CanarySize: 1}, directory, directory.Devices())
+	runner := &RolloutRunner{Upgrader: &FirmwareUpgrader{Stream: stream}, Directory: directory, MinFinished: 1}
+
+	if err := runner.Run(context.Background(), rollout); err == nil {
+		t.Fatal("rollout with a failed canary completed")
+	} else if _, ok := err.(RolloutHaltedError); !ok {
+		t.Fatalf("err = %v", err)
+	}
+
+	if !rollout.Halted || len(stream.upgraded) != 1 || rollout.Devices[1].State != RolloutPending {
+		t.Fatalf("halted %v after upgrading %v", rollout.Halted, stream.upgraded)
+	}
+}
+
+func TestRolloutRunnerWaitsForSkippedCanary(t *testing.T) {
+	stream := &rolloutStream{}
+	directory := gatewayDirectory{"b": "gw", "c": "gw"}
+
+	// The canary a is offline.
+	rollout := NewFirmwareRollout("fw.bin", RolloutPlan{CanarySize: 1}, directory, []string{"a", "b", "c"})
+	runner := &RolloutRunner{Upgrader: &FirmwareUpgrader{Stream: stream}, Directory: directory}
+
+	err := runner.Run(context.Background(), rollout)
+
+	if incomplete, ok := err.(RolloutWaveIncompleteError); !ok || incomplete.Wave != 0 || len(incomplete.Devices) != 1 {
+		t.Fatalf("err = %v", err)
+	}
+
+	if len(stream.upgraded) != 0 || rollout.Devices[0].State != RolloutSkipped {
+		t.Fatalf("upgraded %v with the canary %+v", stream.upgraded, rollout.Devices[0])
+	}
+
+	directory["a"] = "gw"
+
+	if err = runner.Run(context.Background(), rollout); err != nil || !rollout.Done() {
+		t.Fatalf("second run: %v, done %v", err, rollout.Done())
+	}
+}
+
+func TestRolloutRunnerLimitsUpgradesPerGateway(t *testing.T) {
+	stream := &rolloutStream{}
+	directory := gatewayDirectory{"a": "gw", "b": "gw", "c": "gw", "d": "gw", "e": "gw"}
+
+	rollout := NewFirmwareRollout("fw.bin", RolloutPlan{}, directory, directory.Devices())
+	runner := &RolloutRunner{Upgrader: &FirmwareUpgrader{Stream: stream}, Directory: directory, PerGateway: 2}
+
+	if err := runner.Run(context.Background(), rollout); err != nil {
+		t.Fatal(err)
+	}
+
+	if stream.peak != 2 {
+		t.Fatalf("%d upgrades ran at once on a gateway with two slots", stream.peak)
+	}
+
+	if !rollout.Done() || len(stream.upgraded) != 5 {
+		t.Fatalf("upgraded %v", stream.upgraded)
+	}
+}
+
+func TestFirmwareRolloutSavesStalledDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rollout.json")
+
+	rollout := NewFirmwareRollout("fw.bin", RolloutPlan{CanarySize: 1}, nil, []string{"a", "b"})
+
+	stalled := FirmwareStallError{ExtAddr: "a", BlockNr: 3, Timeout: time.Minute}
+	rollout.Devices[0].State = RolloutFailed
+	rollout.Devices[0].Result = &FirmwareUpgradeResult{ExtAddr: "a", FileName: "fw.bin", BlockNr: 3, TotalBlocksNr: 10, Aborted: true}
+	rollout.Devices[0].Error = stalled.Error()
+	rollout.Devices[0].FailureCounted = rolloutFailure(stalled)
+
+	if err := rollout.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFirmwareRollout(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := loaded.Devices[0]
+
+	if d.State != RolloutFailed || d.Result == nil || d.Result.BlockNr != 3 || d.Result.Status != "" || !d.FailureCounted {
+		t.Fatalf("loaded %+v", d)
+	}
+
+	if loaded.FailureRate() != rollout.FailureRate() {
+		t.Fatalf("failure rate %v, want %v", loaded.FailureRate(), rollout.FailureRate())
+	}
+}
@@ -0,0 +1,223 @@
+package messages
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// DefaultFirmwareBlockSize is used when a FirmwareBlockServer has no BlockSize. It is a conservative choice, not a
+// limit of the devices.
+const DefaultFirmwareBlockSize = 256
+
+// FirmwareRepository stores firmware files by the fileName sent in FirmwareVersionUpgradeRequest.
+type FirmwareRepository interface {
+	Put(fileName string, file []byte) error
+	Size(fileName string) (int64, error)
+	ReadAt(fileName string, p []byte, offset int64) (int, error)
+}
+
+// DirFirmwareRepository keeps every firmware file as a file of the same name in Dir.
+type DirFirmwareRepository struct {
+	Dir string
+}
+
+func (r *DirFirmwareRepository) path(fileName string) (string, error) {
+	if fileName == "" || fileName == "." || fileName == ".." || strings.ContainsAny(fileName, `/\`) {
+		return "", InvalidFirmwareFileName{fileName}
+	}
+
+	return filepath.Join(r.Dir, fileName), nil
+}
+
+func (r *DirFirmwareRepository) Put(fileName string, file []byte) error {
+	path, err := r.path(fileName)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, file, 0640)
+}
+
+func (r *DirFirmwareRepository) Size(fileName string) (int64, error) {
+	path, err := r.path(fileName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) {
+		return 0, FirmwareFileNotFound{fileName}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+func (r *DirFirmwareRepository) ReadAt(fileName string, p []byte, offset int64) (int, error) {
+	path, err := r.path(fileName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return 0, FirmwareFileNotFound{fileName}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	return file.ReadAt(p, offset)
+}
+
+// FirmwareBlock is block BlockNr, counted from 0, of a firmware file with TotalBlocksNr blocks.
+type FirmwareBlock struct {
+	FileName      string
+	BlockNr       int
+	TotalBlocksNr int
+	Data          []byte
+}
+
+// FirmwareBlockRequest asks for block BlockNr of FileName. TotalBlocksNr is the block count the requester
+// expects, or 0 when it does not know it yet.
+type FirmwareBlockRequest struct {
+	FileName      string
+	BlockNr       int
+	TotalBlocksNr int
+}
+
+// FirmwareBlockCodec translates the messages a gateway fetches firmware blocks with. The protocol does not define
+// them, so the codec comes with the integration of the gateway.
+type FirmwareBlockCodec interface {
+	// DecodeRequest reports ok false for events that are not block requests.
+	DecodeRequest(event []byte) (request FirmwareBlockRequest, ok bool)
+	EncodeBlock(request FirmwareBlockRequest, block *FirmwareBlock) json.Marshaler
+	// EncodeError returns nil when the gateway is not told about a request that cannot be answered.
+	EncodeError(request FirmwareBlockRequest, err error) json.Marshaler
+}
+
+// FirmwareBlockServer splits the files of the Repository into blocks. Answer serves a single request; Serve
+// answers the requests a gateway sends on the Stream, in the messages of the Codec.
+type FirmwareBlockServer struct {
+	Repository FirmwareRepository
+	BlockSize  int
+	Stream     EventStream
+	Codec      FirmwareBlockCodec
+	OnError    func(FirmwareBlockRequest, error)
+}
+
+func (s *FirmwareBlockServer) blockSize() int {
+	if s.BlockSize > 0 {
+		return s.BlockSize
+	}
+
+	return DefaultFirmwareBlockSize
+}
+
+func (s *FirmwareBlockServer) TotalBlocks(fileName string) (int, error) {
+	size, err := s.Repository.Size(fileName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	blockSize := int64(s.blockSize())
+
+	return int((size + blockSize - 1) / blockSize), nil
+}
+
+// Answer returns block blockNr of fileName. totalBlocksNr is the block count the requester expects, or 0 when it
+// does not know it yet. Another count than the file has is refused, as the requester would assemble a wrong image.
+func (s *FirmwareBlockServer) Answer(fileName string, blockNr, totalBlocksNr int) (*FirmwareBlock, error) {
+	total, err := s.TotalBlocks(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if totalBlocksNr != 0 && totalBlocksNr != total {
+		return nil, FirmwareBlockCountError{FileName: fileName, Expected: total, Got: totalBlocksNr}
+	}
+
+	if blockNr < 0 || blockNr >= total {
+		return nil, FirmwareBlockRangeError{FileName: fileName, BlockNr: blockNr, TotalBlocksNr: total}
+	}
+
+	data := make([]byte, s.blockSize())
+	n, err := s.Repository.ReadAt(fileName, data, int64(blockNr*s.blockSize()))
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return &FirmwareBlock{FileName: fileName, BlockNr: blockNr, TotalBlocksNr: total, Data: data[:n]}, nil
+}
+
+// Serve answers the block requests of the gateway extAddr until ctx ends. Requests that cannot be answered are
+// passed to OnError; only a failure to send ends Serve.
+func (s *FirmwareBlockServer) Serve(ctx context.Context, extAddr string) error {
+	events, err := s.Stream.Events(ctx, extAddr)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case raw, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				return EventStreamClosedError{ExtAddr: extAddr}
+			}
+
+			request, ok := s.Codec.DecodeRequest(raw)
+
+			if !ok {
+				continue
+			}
+
+			var answer json.Marshaler
+
+			block, err := s.Answer(request.FileName, request.BlockNr, request.TotalBlocksNr)
+
+			if err == nil {
+				answer = s.Codec.EncodeBlock(request, block)
+			} else {
+				if s.OnError != nil {
+					s.OnError(request, err)
+				}
+
+				answer = s.Codec.EncodeError(request, err)
+			}
+
+			if answer == nil {
+				continue
+			}
+
+			if err = s.Stream.Send(ctx, extAddr, answer); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestDirFirmwareRepository(t *testing.T) {
+	repository := &DirFirmwareRepository{Dir: t.TempDir()}
+	file := []byte("0123456789")
+
+	if err := repository.Put("fw.bin", file); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := repository.Size("fw.bin")
+	if err != nil || size != int64(len(file)) {
+		t.Fatalf("size %d, %v", size, err)
+	}
+
+	p := make([]byte, 4)
+	if n, err := repository.ReadAt("fw.bin", p, 3); err != nil || !bytes.Equal(p[:n], []byte("3456")) {
+		t.Fatalf("read %q, %v", p[:n], err)
+	}
+
+	if _, err = repository.Size("missing.bin"); err != (FirmwareFileNotFound{"missing.bin"}) {
+		t.Fatalf("missing file: %v", err)
+	}
+
+	for _, name := range []string{"", "..", "../fw.bin", `dir\fw.bin`} {
+		if err = repository.Put(name, file); err != (InvalidFirmwareFileName{name}) {
+			t.Fatalf("put %q: %v", name, err)
+		}
+	}
+}
+
+func TestFirmwareBlockServerAnswer(t *testing.T) {
+	repository := &DirFirmwareRepository{Dir: t.TempDir()}
+
+	if err := repository.Put("fw.bin", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &FirmwareBlockServer{Repository: repository, BlockSize: 4}
+
+	block, err := server.Answer("fw.bin", 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.TotalBlocksNr != 3 || !bytes.Equal(block.Data, []byte("89")) {
+		t.Fatalf("last block %+v", block)
+	}
+
+	if _, err = server.Answer("fw.bin", 0, 2); err == nil {
+		t.Fatal("answered a request expecting another block count")
+	}
+
+	if _, err = server.Answer("fw.bin", 3, 0); err == nil {
+		t.Fatal("answered a block past the end of the file")
+	}
+}
+
+// testBlockMessage stands in for the messages of a gateway integration, which the protocol does not define.
+type testBlockMessage struct {
+	Type     string `json:"type"`
+	FileName string `json:"fileName"`
+	BlockNr  int    `json:"blockNr"`
+	Total    int    `json:"total"`
+	Data     []byte `json:"data,omitempty"`
+}
+
+func (m *testBlockMessage) MarshalJSON() ([]byte, error) {
+	type message testBlockMessage
+	return json.Marshal((*message)(m))
+}
+
+type testBlockCodec struct{}
+
+func (testBlockCodec) DecodeRequest(event []byte) (FirmwareBlockRequest, bool) {
+	var m testBlockMessage
+
+	if json.Unmarshal(event, &m) != nil || m.Type != "blockReq" {
+		return FirmwareBlockRequest{}, false
+	}
+
+	return FirmwareBlockRequest{FileName: m.FileName, BlockNr: m.BlockNr, TotalBlocksNr: m.Total}, true
+}
+
+func (testBlockCodec) EncodeBlock(_ FirmwareBlockRequest, block *FirmwareBlock) json.Marshaler {
+	return &testBlockMessage{Type: "block", FileName: block.FileName, BlockNr: block.BlockNr, Total: block.TotalBlocksNr, Data: block.Data}
+}
+
+func (testBlockCodec) EncodeError(FirmwareBlockRequest, error) json.Marshaler { return nil }
+
+func TestFirmwareBlockServerServesStream(t *testing.T) {
+	repository := &DirFirmwareRepository{Dir: t.TempDir()}
+
+	if err := repository.Put("fw.bin", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := newFakeEventStream()
+
+	var failed []FirmwareBlockRequest
+	server := &FirmwareBlockServer{
+		Repository: repository,
+		BlockSize:  4,
+		Stream:     stream,
+		Codec:      testBlockCodec{},
+		OnError:    func(request FirmwareBlockRequest, _ error) { failed = append(failed, request) },
+	}
+
+	stream.push(t, &testBlockMessage{Type: "blockReq", FileName: "fw.bin", BlockNr: 1})
+	stream.push(t, &testBlockMessage{Type: "other"})
+	stream.push(t, &testBlockMessage{Type: "blockReq", FileName: "missing.bin"})
+	stream.push(t, &testBlockMessage{Type: "blockReq", FileName: "fw.bin", BlockNr: 2, Total: 3})
+
+	answers := 0
+	stream.onSend = func(json.Marshaler) {
+		if answers++; answers == 2 {
+			cancel()
+		}
+	}
+
+	if err := server.Serve(ctx, "gateway"); err != context.Canceled {
+		t.Fatalf("err = %v", err)
+	}
+
+	if len(stream.sent) != 2 || len(failed) != 1 || failed[0].FileName != "missing.bin" {
+		t.Fatalf("sent %v, failed %v", stream.sent, failed)
+	}
+
+	for i, want := range []string{"4567", "89"} {
+		if block := stream.sent[i].(*testBlockMessage); block.BlockNr != i+1 || block.Total != 3 || string(block.Data) != want {
+			t.Errorf("answer %d = %+v", i, block)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package messages
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TransferStats struct {
+	ExtAddr         string        `json:"extAddr"`
+	Gateway         string        `json:"gateway,omitempty"`
+	FileName        string        `json:"fileName"`
+	BlockNr         int           `json:"blockNr"`
+	TotalBlocksNr   int           `json:"totalBlocksNr"`
+	BlocksPerSecond float64       `json:"blocksPerSecond"`
+	ETA             time.Duration `json:"eta"`
+	StartedAt       time.Time     `json:"startedAt"`
+	LastBlockAt     time.Time     `json:"lastBlockAt"`
+	Stalled         bool          `json:"stalled"`
+}
+
+type GatewayTransferStats struct {
+	Gateway         string  `json:"gateway"`
+	Transfers       int     `json:"transfers"`
+	Stalled         int     `json:"stalled"`
+	BlocksPerSecond float64 `json:"blocksPerSecond"`
+}
+
+// MeanBlocksPerSecond is the throughput of an average transfer; it drops when the mesh of the gateway congests.
+func (g GatewayTransferStats) MeanBlocksPerSecond() float64 {
+	if g.Transfers == 0 {
+		return 0
+	}
+
+	return g.BlocksPerSecond / float64(g.Transfers)
+}
+
+type transferSample struct {
+	blockNr int
+	at      time.Time
+}
+
+type transfer struct {
+	stats   TransferStats
+	samples []transferSample
+}
+
+// FirmwareTransferMonitor derives throughput and ETA of running transfers from their block progress, over the
+// last Window blocks. A transfer without a new block for StallAfter is reported stalled.
+type FirmwareTransferMonitor struct {
+	Directory  *NetworkDirectory
+	Window     int
+	StallAfter time.Duration
+	Now        func() time.Time
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+}
+
+func (m *FirmwareTransferMonitor) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+
+	return time.Now()
+}
+
+// Observe records p and returns p with the current throughput and ETA of its transfer.
+func (m *FirmwareTransferMonitor) Observe(p FirmwareProgress) FirmwareProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.transfers == nil {
+		m.transfers = make(map[string]*transfer)
+	}
+
+	t := m.transfers[p.ExtAddr]
+
+	if t == nil || t.stats.FileName != p.FileName || p.BlockNr < t.stats.BlockNr {
+		t = &transfer{stats: TransferStats{ExtAddr: p.ExtAddr, FileName: p.FileName, StartedAt: p.At}}
+
+		if m.Directory != nil {
+			t.stats.Gateway, _ = m.Directory.Gateway(p.ExtAddr)
+		}
+
+		m.transfers[p.ExtAddr] = t
+	}
+
+	window := m.Window
+	if window < 2 {
+		window = 16
+	}
+
+	t.samples = append(t.samples, transferSample{p.BlockNr, p.At})
+	if len(t.samples) > window {
+		t.samples = t.samples[len(t.samples)-window:]
+	}
+
+	t.stats.BlockNr, t.stats.TotalBlocksNr, t.stats.LastBlockAt = p.BlockNr, p.TotalBlocksNr, p.At
+	t.stats.BlocksPerSecond, t.stats.ETA = 0, 0
+
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+
+	if elapsed := last.at.Sub(first.at); elapsed > 0 && last.blockNr > first.blockNr {
+		t.stats.BlocksPerSecond = float64(last.blockNr-first.blockNr) / elapsed.Seconds()
+
+		if remaining := p.TotalBlocksNr - p.BlockNr; remaining > 0 {
+			t.stats.ETA = time.Duration(float64(remaining) / t.stats.BlocksPerSecond * float64(time.Second))
+		}
+	}
+
+	p.BlocksPerSecond, p.ETA = t.stats.BlocksPerSecond, t.stats.ETA
+
+	return p
+}
+
+func (m *FirmwareTransferMonitor) Finish(extAddr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.transfers, extAddr)
+}
+
+func (m *FirmwareTransferMonitor) stats(t *transfer, now time.Time) TransferStats {
+	s := t.stats
+	s.Stalled = m.StallAfter > 0 && now.Sub(s.LastBlockAt) > m.StallAfter
+
+	return s
+}
+
+func (m *FirmwareTransferMonitor) Transfer(extAddr string) (TransferStats, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.transfers[extAddr]
+
+	if !ok {
+		return TransferStats{}, false
+	}
+
+	return m.stats(t, m.now()), true
+}
+
+func (m *FirmwareTransferMonitor) Transfers() []TransferStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	transfers := make([]TransferStats, 0, len(m.transfers))
+
+	for _, t := range m.transfers {
+		transfers = append(transfers, m.stats(t, now))
+	}
+
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].ExtAddr < transfers[j].ExtAddr })
+
+	return transfers
+}
+
+func (m *FirmwareTransferMonitor) Gateways() []GatewayTransferStats {
+	byGateway := make(map[string]*GatewayTransferStats)
+
+	for _, t := range m.Transfers() {
+		g := byGateway[t.Gateway]
+
+		if g == nil {
+			g = &GatewayTransferStats{Gateway: t.Gateway}
+			byGateway[t.Gateway] = g
+		}
+
+		g.Transfers++
+		g.BlocksPerSecond += t.BlocksPerSecond
+
+		if t.Stalled {
+			g.Stalled++
+		}
+	}
+
+	gateways := make([]GatewayTransferStats, 0, len(byGateway))
+	for _, g := range byGateway {
+		gateways = append(gateways, *g)
+	}
+
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].Gateway < gateways[j].Gateway })
+
+	return gateways
+}
+
+// WriteMetrics writes the current statistics in the Prometheus text exposition format.
+func (m *FirmwareTransferMonitor) WriteMetrics(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	transfers := m.Transfers()
+	gateways := m.Gateways()
+
+	metric := func(name, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	transferMetric := func(name, help string, value func(TransferStats) float64) {
+		metric(name, help)
+
+		for _, t := range transfers {
+			fmt.Fprintf(b, "%s{ext_addr=\"%s\",gateway=\"%s\",file_name=\"%s\"} %g\n", name,
+				escapeMetricLabel(t.ExtAddr), escapeMetricLabel(t.Gateway), escapeMetricLabel(t.FileName), value(t))
+		}
+	}
+
+	gatewayMetric := func(name, help string, value func(GatewayTransferStats) float64) {
+		metric(name, help)
+
+		for _, g := range gateways {
+			fmt.Fprintf(b, "%s{gateway=\"%s\"} %g\n", name, escapeMetricLabel(g.Gateway), value(g))
+		}
+	}
+
+	transferMetric("firmware_transfer_block", "Last block confirmed by the device.",
+		func(t TransferStats) float64 { return float64(t.BlockNr) })
+	transferMetric("firmware_transfer_total_blocks", "Blocks of the firmware file.",
+		func(t TransferStats) float64 { return float64(t.TotalBlocksNr) })
+	transferMetric("firmware_transfer_blocks_per_second", "Recent transfer throughput.",
+		func(t TransferStats) float64 { return t.BlocksPerSecond })
+	transferMetric("firmware_transfer_eta_seconds", "Estimated time until the last block, 0 when unknown.",
+		func(t TransferStats) float64 { return t.ETA.Seconds() })
+	transferMetric("firmware_transfer_stalled", "1 when the transfer made no progress recently.",
+		func(t TransferStats) float64 { return boolMetric(t.Stalled) })
+	gatewayMetric("firmware_gateway_transfers", "Running transfers through the gateway.",
+		func(g GatewayTransferStats) float64 { return float64(g.Transfers) })
+	gatewayMetric("firmware_gateway_stalled_transfers", "Stalled transfers through the gateway.",
+		func(g GatewayTransferStats) float64 { return float64(g.Stalled) })
+	gatewayMetric("firmware_gateway_blocks_per_second", "Combined throughput of the transfers through the gateway.",
+		func(g GatewayTransferStats) float64 { return g.BlocksPerSecond })
+
+	return b.Flush()
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeMetricLabel(s string) string { return metricLabelEscaper.Replace(s) }
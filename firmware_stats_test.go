@@ -0,0 +1,105 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestFirmwareTransferMonitorThroughputAndETA(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	monitor := &FirmwareTransferMonitor{Window: 3, StallAfter: 5 * time.Second, Now: c.Now}
+
+	observe := func(blockNr int) FirmwareProgress {
+		return monitor.Observe(FirmwareProgress{ExtAddr: "a", FileName: "fw.bin", BlockNr: blockNr, TotalBlocksNr: 100, At: c.Now()})
+	}
+
+	if p := observe(0); p.BlocksPerSecond != 0 || p.ETA != 0 {
+		t.Fatalf("first block %+v", p)
+	}
+
+	c.advance(time.Second)
+	observe(40)
+	c.advance(time.Second)
+	observe(50)
+	c.advance(time.Second)
+
+	// The window holds the last three blocks, 40 to 60 over two seconds.
+	if p := observe(60); p.BlocksPerSecond != 10 || p.ETA != 4*time.Second {
+		t.Fatalf("progress %+v", p)
+	}
+
+	c.advance(6 * time.Second)
+
+	stats, ok := monitor.Transfer("a")
+	if !ok || !stats.Stalled || stats.BlockNr != 60 || !stats.StartedAt.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("stats %+v", stats)
+	}
+
+	if gateways := monitor.Gateways(); len(gateways) != 1 || gateways[0].Stalled != 1 || gateways[0].MeanBlocksPerSecond() != 10 {
+		t.Fatalf("gateways %+v", gateways)
+	}
+
+	var metrics bytes.Buffer
+	if err := monitor.WriteMetrics(&metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(metrics.String(), `firmware_transfer_eta_seconds{ext_addr="a",gateway="",file_name="fw.bin"} 4`+"\n") {
+		t.Fatalf("metrics:\n%s", metrics.String())
+	}
+
+	// A new file, or a block behind the last one, starts the statistics over.
+	if p := monitor.Observe(FirmwareProgress{ExtAddr: "a", FileName: "fw.bin", BlockNr: 1, TotalBlocksNr: 100, At: c.Now()}); p.BlocksPerSecond != 0 {
+		t.Fatalf("restarted transfer %+v", p)
+	}
+
+	monitor.Finish("a")
+
+	if _, ok = monitor.Transfer("a"); ok || len(monitor.Transfers()) != 0 {
+		t.Fatal("finished transfer is still reported")
+	}
+}
+
+func TestFirmwareUpgraderReportsThroughput(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tick := func() time.Time { c.advance(time.Second); return c.Now() }
+
+	stream := newFakeEventStream()
+	monitor := &FirmwareTransferMonitor{Now: c.Now}
+	upgrader := &FirmwareUpgrader{Stream: stream, Monitor: monitor, Now: tick}
+
+	stream.onSend = func(request json.Marshaler) {
+		if _, ok := request.(*FirmwareVersionUpgradeRequest); ok {
+			for blockNr := 1; blockNr <= 3; blockNr++ {
+				stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: blockNr, TotalBlocksNr: 4})
+			}
+
+			stream.push(t, &FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeSuccessStatus})
+		}
+	}
+
+	progress := make(chan FirmwareProgress, 3)
+
+	if _, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", progress); err != nil {
+		t.Fatal(err)
+	}
+
+	var last FirmwareProgress
+	for len(progress) > 0 {
+		last = <-progress
+	}
+
+	// One block a second leaves one second for the last block.
+	if last.BlockNr != 3 || last.BlocksPerSecond != 1 || last.ETA != time.Second {
+		t.Fatalf("last progress %+v", last)
+	}
+
+	if _, ok := monitor.Transfer("a"); ok {
+		t.Fatal("finished upgrade is still monitored")
+	}
+}
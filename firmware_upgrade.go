@@ -0,0 +1,244 @@
+package messages
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// EventStream delivers the messages a device sends on its own, such as firmware block progress, and sends
+// requests that are answered through those messages. Events stops delivering and closes the channel when ctx ends.
+type EventStream interface {
+	Events(ctx context.Context, extAddr string) (<-chan []byte, error)
+	Send(ctx context.Context, extAddr string, request json.Marshaler) error
+}
+
+type FirmwareProgress struct {
+	ExtAddr         string        `json:"extAddr"`
+	FileName        string        `json:"fileName"`
+	BlockNr         int           `json:"blockNr"`
+	TotalBlocksNr   int           `json:"totalBlocksNr"`
+	BlocksPerSecond float64       `json:"blocksPerSecond"`
+	ETA             time.Duration `json:"eta"`
+	At              time.Time     `json:"at"`
+}
+
+func (p FirmwareProgress) Ratio() float64 {
+	if p.TotalBlocksNr <= 0 {
+		return 0
+	}
+
+	return float64(p.BlockNr) / float64(p.TotalBlocksNr)
+}
+
+type FirmwareUpgradeResult struct {
+	ExtAddr       string                `json:"extAddr"`
+	FileName      string                `json:"fileName"`
+	Status        firmwareUpgradeStatus `json:"status,omitempty"`
+	ErrorCode     int                   `json:"errorCode"`
+	BlockNr       int                   `json:"blockNr"`
+	TotalBlocksNr int                   `json:"totalBlocksNr"`
+	Aborted       bool                  `json:"aborted"`
+	StartedAt     time.Time             `json:"startedAt"`
+	FinishedAt    time.Time             `json:"finishedAt"`
+}
+
+// FirmwareUpgrader runs upgrades over an EventStream. The device reports every block it fetched with a
+// fwBlockRsp and the outcome of the upgrade with a final fwUpdateRsp. An upgrade whose block number does not
+// advance for StallTimeout is aborted. With a Monitor, progress carries throughput and ETA.
+type FirmwareUpgrader struct {
+	Stream       EventStream
+	StallTimeout time.Duration
+	AbortTimeout time.Duration
+	Monitor      *FirmwareTransferMonitor
+	Now          func() time.Time
+
+	transactionId uint32
+}
+
+func (u *FirmwareUpgrader) nextTransactionId() uint32 { return atomic.AddUint32(&u.transactionId, 1) }
+
+func (u *FirmwareUpgrader) now() time.Time {
+	if u.Now != nil {
+		return u.Now()
+	}
+
+	return time.Now()
+}
+
+// Upgrade asks the device to install fileName and waits for the outcome. Progress is sent to progress without
+// blocking, so a slow reader misses updates rather than delaying the upgrade; progress may be nil. The upgrade is
+// aborted on the device when ctx ends or the transfer stalls.
+func (u *FirmwareUpgrader) Upgrade(ctx context.Context, extAddr, fileName string, progress chan<- FirmwareProgress) (FirmwareUpgradeResult, error) {
+	return u.upgrade(ctx, extAddr, fileName, progress, upgradeRun{})
+}
+
+// upgradeRun changes how upgrade treats the transfer.
+type upgradeRun struct {
+	// resume follows the transfer the device holds from this checkpoint instead of requesting one. The device
+	// must report a block of it within probe, otherwise the upgrade ends with a FirmwareResumeError.
+	resume *FirmwareCheckpoint
+	probe  time.Duration
+	// keep tells whether to leave the transfer on the device when ctx ends or it stalls, so that it can be resumed.
+	keep func() bool
+	// observe, unlike progress, sees every block and may block the upgrade.
+	observe func(FirmwareProgress)
+}
+
+func (u *FirmwareUpgrader) upgrade(ctx context.Context, extAddr, fileName string, progress chan<- FirmwareProgress, run upgradeRun) (FirmwareUpgradeResult, error) {
+	result := FirmwareUpgradeResult{ExtAddr: extAddr, FileName: fileName, StartedAt: u.now()}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := u.Stream.Events(streamCtx, extAddr)
+
+	if err != nil {
+		return result, err
+	}
+
+	if u.Monitor != nil {
+		defer u.Monitor.Finish(extAddr)
+	}
+
+	stop := func(err error) (FirmwareUpgradeResult, error) {
+		if run.keep != nil && run.keep() {
+			result.FinishedAt = u.now()
+			return result, err
+		}
+
+		return u.abort(result), err
+	}
+
+	// probed fires when a resumed transfer has not shown up on the device in time.
+	var probed <-chan time.Time
+
+	if run.resume != nil {
+		result.BlockNr, result.TotalBlocksNr = run.resume.BlockNr, run.resume.TotalBlocksNr
+
+		probe := time.NewTimer(run.probe)
+		probed = probe.C
+		defer probe.Stop()
+	} else if err = u.Stream.Send(ctx, extAddr, &FirmwareVersionUpgradeRequest{TransactionId: u.nextTransactionId(), FileName: fileName}); err != nil {
+		return result, err
+	}
+
+	// A zero StallTimeout disables stall detection.
+	var stalled <-chan time.Time
+	var stall *time.Timer
+
+	if u.StallTimeout > 0 {
+		stall = time.NewTimer(u.StallTimeout)
+		stalled = stall.C
+		defer stall.Stop()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stop(ctx.Err())
+		case <-probed:
+			result.FinishedAt = u.now()
+			return result, FirmwareResumeError{ExtAddr: extAddr, FileName: fileName, Reason: "device reported no block of the transfer"}
+		case <-stalled:
+			return stop(FirmwareStallError{ExtAddr: extAddr, BlockNr: result.BlockNr, TotalBlocksNr: result.TotalBlocksNr, Timeout: u.StallTimeout})
+		case raw, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return stop(ctx.Err())
+				}
+
+				result.FinishedAt = u.now()
+				return result, EventStreamClosedError{ExtAddr: extAddr}
+			}
+
+			t, message, err := peekEvent(raw)
+
+			if err != nil {
+				continue
+			}
+
+			switch t {
+			case FwBlockResponseEventType:
+				var block FirmwareBlockResponse
+
+				if err = json.Unmarshal(message, &block); err != nil {
+					continue
+				}
+
+				if probed != nil {
+					if block.TotalBlocksNr != run.resume.TotalBlocksNr || block.BlockNr < run.resume.BlockNr {
+						result.FinishedAt = u.now()
+						return result, FirmwareResumeError{ExtAddr: extAddr, FileName: fileName, Reason: "device runs another transfer"}
+					}
+
+					probed = nil
+				}
+
+				advanced := block.BlockNr != result.BlockNr || block.TotalBlocksNr != result.TotalBlocksNr
+				result.BlockNr, result.TotalBlocksNr = block.BlockNr, block.TotalBlocksNr
+
+				if !advanced {
+					continue
+				}
+
+				if stall != nil {
+					if !stall.Stop() {
+						<-stall.C
+					}
+
+					stall.Reset(u.StallTimeout)
+				}
+
+				p := FirmwareProgress{ExtAddr: extAddr, FileName: fileName, BlockNr: block.BlockNr, TotalBlocksNr: block.TotalBlocksNr, At: u.now()}
+
+				if u.Monitor != nil {
+					p = u.Monitor.Observe(p)
+				}
+
+				if run.observe != nil {
+					run.observe(p)
+				}
+
+				if progress != nil {
+					select {
+					case progress <- p:
+					default:
+					}
+				}
+			case FwVersionUpdateResponseEventType:
+				var rsp FirmwareVersionUpgradeResponse
+
+				if err = json.Unmarshal(message, &rsp); err != nil {
+					continue
+				}
+
+				result.Status, result.ErrorCode = rsp.Status, rsp.ErrorCode
+				result.FinishedAt = u.now()
+
+				rsp.ExtAddr = extAddr
+
+				return result, rsp.Err()
+			}
+		}
+	}
+}
+
+// abort tells the device to stop the upgrade. It runs on its own deadline, as the context of the upgrade may be
+// over already.
+func (u *FirmwareUpgrader) abort(result FirmwareUpgradeResult) FirmwareUpgradeResult {
+	timeout := u.AbortTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result.Aborted = u.Stream.Send(ctx, result.ExtAddr, &FirmwareUpdateAbort{TransactionId: u.nextTransactionId()}) == nil
+	result.FinishedAt = u.now()
+
+	return result
+}
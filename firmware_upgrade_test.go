@@ -0,0 +1,158 @@
+package messages
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// fakeEventStream delivers what the test puts into events. onSend runs for every request the upgrader sends.
+type fakeEventStream struct {
+	events chan []byte
+	onSend func(request json.Marshaler)
+
+	mu   sync.Mutex
+	sent []json.Marshaler
+}
+
+func newFakeEventStream() *fakeEventStream {
+	return &fakeEventStream{events: make(chan []byte, 16)}
+}
+
+func (s *fakeEventStream) Events(context.Context, string) (<-chan []byte, error) {
+	return s.events, nil
+}
+
+func (s *fakeEventStream) Send(_ context.Context, _ string, request json.Marshaler) error {
+	s.mu.Lock()
+	s.sent = append(s.sent, request)
+	s.mu.Unlock()
+
+	if s.onSend != nil {
+		s.onSend(request)
+	}
+
+	return nil
+}
+
+func (s *fakeEventStream) push(t *testing.T, message json.Marshaler) {
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.events <- bytes
+}
+
+func (s *fakeEventStream) requests() (upgrades, aborts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, request := range s.sent {
+		switch request.(type) {
+		case *FirmwareVersionUpgradeRequest:
+			upgrades++
+		case *FirmwareUpdateAbort:
+			aborts++
+		}
+	}
+
+	return upgrades, aborts
+}
+
+func TestFirmwareUpgraderReportsProgressAndSuccess(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := &FirmwareUpgrader{Stream: stream, StallTimeout: time.Second}
+
+	stream.onSend = func(request json.Marshaler) {
+		if _, ok := request.(*FirmwareVersionUpgradeRequest); ok {
+			stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: 1, TotalBlocksNr: 2})
+			stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: 2, TotalBlocksNr: 2})
+			stream.push(t, &FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeSuccessStatus})
+		}
+	}
+
+	progress := make(chan FirmwareProgress, 2)
+
+	result, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Status != UpgradeSuccessStatus || result.BlockNr != 2 || result.Aborted {
+		t.Fatalf("result %+v", result)
+	}
+
+	if len(progress) != 2 {
+		t.Fatalf("reported progress %d times, want 2", len(progress))
+	}
+
+	if p := <-progress; p.BlockNr != 1 || p.Ratio() != 0.5 {
+		t.Fatalf("first progress %+v", p)
+	}
+}
+
+func TestFirmwareUpgraderReturnsDeviceStatus(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := &FirmwareUpgrader{Stream: stream}
+
+	stream.push(t, &FirmwareVersionUpgradeResponse{ExtAddr: "a", Status: UpgradeInvalidFileStatus, ErrorCode: 3})
+
+	result, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil)
+
+	upgradeErr, ok := err.(FirmwareUpgradeError)
+	if !ok || upgradeErr.ExtAddr != "a" || upgradeErr.Status != UpgradeInvalidFileStatus || upgradeErr.ErrorCode != 3 {
+		t.Fatalf("err = %v", err)
+	}
+
+	if result.Status != UpgradeInvalidFileStatus || result.Aborted {
+		t.Fatalf("result %+v", result)
+	}
+
+	if _, aborts := stream.requests(); aborts != 0 {
+		t.Fatal("aborted an upgrade the device already ended")
+	}
+}
+
+func TestFirmwareUpgraderAbortsStalledTransfer(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := &FirmwareUpgrader{Stream: stream, StallTimeout: 20 * time.Millisecond}
+
+	stream.push(t, &FirmwareBlockResponse{ExtAddr: "a", BlockNr: 3, TotalBlocksNr: 10})
+
+	result, err := upgrader.Upgrade(context.Background(), "a", "fw.bin", nil)
+
+	if stall, ok := err.(FirmwareStallError); !ok || stall.BlockNr != 3 || stall.TotalBlocksNr != 10 {
+		t.Fatalf("err = %v", err)
+	}
+
+	if _, aborts := stream.requests(); aborts != 1 || !result.Aborted {
+		t.Fatalf("sent %d aborts, result %+v", aborts, result)
+	}
+}
+
+func TestFirmwareUpgraderAbortsWhenCancelled(t *testing.T) {
+	stream := newFakeEventStream()
+	upgrader := &FirmwareUpgrader{Stream: stream}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream.onSend = func(request json.Marshaler) {
+		if _, ok := request.(*FirmwareVersionUpgradeRequest); ok {
+			cancel()
+		}
+	}
+
+	result, err := upgrader.Upgrade(ctx, "a", "fw.bin", nil)
+
+	if err != context.Canceled {
+		t.Fatalf("err = %v", err)
+	}
+
+	if upgrades, aborts := stream.requests(); upgrades != 1 || aborts != 1 || !result.Aborted {
+		t.Fatalf("sent %d upgrades and %d aborts, result %+v", upgrades, aborts, result)
+	}
+}
@@ -0,0 +1,355 @@
+package messages
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// FirmwareVersion is a lock or gateway firmware version of the form [v]MAJOR[.MINOR[.PATCH[.BUILD]]] with an
+// optional "-PRERELEASE" and "+METADATA" suffix. Missing components are zero, a pre-release orders before its
+// release and metadata does not take part in ordering.
+type FirmwareVersion struct {
+	Numbers    [4]int
+	Components int
+	PreRelease string
+	Metadata   string
+}
+
+func ParseFirmwareVersion(s string) (FirmwareVersion, error) {
+	var v FirmwareVersion
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "v"), "V")
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Metadata, rest = rest[i+1:], rest[:i]
+
+		if v.Metadata == "" {
+			return FirmwareVersion{}, InvalidFirmwareVersion{s}
+		}
+	}
+
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.PreRelease, rest = rest[i+1:], rest[:i]
+
+		if v.PreRelease == "" {
+			return FirmwareVersion{}, InvalidFirmwareVersion{s}
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+
+	if len(parts) > len(v.Numbers) {
+		return FirmwareVersion{}, InvalidFirmwareVersion{s}
+	}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+
+		if err != nil || n < 0 || part == "" || part[0] == '+' {
+			return FirmwareVersion{}, InvalidFirmwareVersion{s}
+		}
+
+		v.Numbers[i] = n
+	}
+
+	v.Components = len(parts)
+
+	return v, nil
+}
+
+func MustParseFirmwareVersion(s string) FirmwareVersion {
+	v, err := ParseFirmwareVersion(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func (v FirmwareVersion) Major() int { return v.Numbers[0] }
+func (v FirmwareVersion) Minor() int { return v.Numbers[1] }
+func (v FirmwareVersion) Patch() int { return v.Numbers[2] }
+
+// Compare returns -1, 0 or 1 when v orders before, equal to or after other.
+func (v FirmwareVersion) Compare(other FirmwareVersion) int {
+	for i := range v.Numbers {
+		if v.Numbers[i] != other.Numbers[i] {
+			return compareInts(v.Numbers[i], other.Numbers[i])
+		}
+	}
+
+	switch {
+	case v.PreRelease == other.PreRelease:
+		return 0
+	case v.PreRelease == "":
+		return 1
+	case other.PreRelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.PreRelease, "."), strings.Split(other.PreRelease, ".")
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+
+		x, errX := strconv.Atoi(a[i])
+		y, errY := strconv.Atoi(b[i])
+
+		switch {
+		case errX == nil && errY == nil:
+			return compareInts(x, y)
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	return compareInts(len(a), len(b))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (v FirmwareVersion) Less(other FirmwareVersion) bool { return v.Compare(other) < 0 }
+
+func (v FirmwareVersion) String() string {
+	components := v.Components
+	if components == 0 {
+		components = 3
+	}
+
+	parts := make([]string, components)
+	for i := range parts {
+		parts[i] = strconv.Itoa(v.Numbers[i])
+	}
+
+	s := strings.Join(parts, ".")
+
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+
+	if v.Metadata != "" {
+		s += "+" + v.Metadata
+	}
+
+	return s
+}
+
+func (v *FirmwareVersion) UnmarshalJSON(bytes []byte) error {
+	var s string
+
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseFirmwareVersion(s)
+
+	if err != nil {
+		return err
+	}
+
+	*v = parsed
+
+	return nil
+}
+
+func (v FirmwareVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+func (f *FirmwareVersionResponse) Version() (FirmwareVersion, error) {
+	return ParseFirmwareVersion(f.FwVersion)
+}
+
+func (g *GetNetworkInfoResponse) Version() (FirmwareVersion, error) {
+	return ParseFirmwareVersion(g.FwVersion)
+}
+
+type firmwareConstraintTerm struct {
+	operator string
+	version  FirmwareVersion
+}
+
+// FirmwareConstraint is a comma separated list of comparisons that must all hold, e.g. ">= 2.3.0, < 3".
+// The operators are =, !=, <, <=, > and >=; a version without operator must match exactly.
+type FirmwareConstraint struct {
+	terms []firmwareConstraintTerm
+}
+
+func ParseFirmwareConstraint(s string) (FirmwareConstraint, error) {
+	var c FirmwareConstraint
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+
+		if term == "" {
+			return FirmwareConstraint{}, InvalidFirmwareConstraint{s}
+		}
+
+		operator := ""
+
+		for _, op := range [...]string{">=", "<=", "!=", ">", "<", "="} {
+			if strings.HasPrefix(term, op) {
+				operator = op
+				term = strings.TrimSpace(term[len(op):])
+				break
+			}
+		}
+
+		if operator == "" {
+			operator = "="
+		}
+
+		v, err := ParseFirmwareVersion(term)
+
+		if err != nil {
+			return FirmwareConstraint{}, InvalidFirmwareConstraint{s}
+		}
+
+		c.terms = append(c.terms, firmwareConstraintTerm{operator, v})
+	}
+
+	return c, nil
+}
+
+func MustParseFirmwareConstraint(s string) FirmwareConstraint {
+	c, err := ParseFirmwareConstraint(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// Matches reports whether v satisfies every comparison. The empty constraint matches any version.
+func (c FirmwareConstraint) Matches(v FirmwareVersion) bool {
+	for _, t := range c.terms {
+		cmp := v.Compare(t.version)
+
+		var ok bool
+
+		switch t.operator {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c FirmwareConstraint) String() string {
+	terms := make([]string, len(c.terms))
+	for i, t := range c.terms {
+		terms[i] = t.operator + " " + t.version.String()
+	}
+
+	return strings.Join(terms, ", ")
+}
+
+func (c *FirmwareConstraint) UnmarshalJSON(bytes []byte) error {
+	var s string
+
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		return err
+	}
+
+	if s == "" {
+		*c = FirmwareConstraint{}
+		return nil
+	}
+
+	parsed, err := ParseFirmwareConstraint(s)
+
+	if err != nil {
+		return err
+	}
+
+	*c = parsed
+
+	return nil
+}
+
+func (c FirmwareConstraint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// FirmwareCompatibility describes the firmware a device type accepts. Targets limits the versions it may be
+// upgraded to and Sources the versions it may be upgraded from; Features maps a feature name to the versions
+// that support it.
+type FirmwareCompatibility struct {
+	Targets        FirmwareConstraint            `json:"targets"`
+	Sources        FirmwareConstraint            `json:"sources"`
+	AllowDowngrade bool                          `json:"allowDowngrade"`
+	Features       map[string]FirmwareConstraint `json:"features,omitempty"`
+}
+
+// FirmwareCompatibilityTable holds the compatibility of every device type. It has no built-in entries; they come
+// from the release notes of the firmware in use.
+type FirmwareCompatibilityTable map[deviceType]FirmwareCompatibility
+
+func (t FirmwareCompatibilityTable) CheckUpgrade(device deviceType, from, to FirmwareVersion) error {
+	c, ok := t[device]
+
+	if !ok {
+		return IncompatibleFirmwareError{DeviceType: device, Version: to, Reason: "device type has no compatibility entry"}
+	}
+
+	switch {
+	case !c.Targets.Matches(to):
+		return IncompatibleFirmwareError{DeviceType: device, Version: to, Reason: "target does not match " + c.Targets.String()}
+	case !c.Sources.Matches(from):
+		return IncompatibleFirmwareError{DeviceType: device, Version: from, Reason: "installed version does not match " + c.Sources.String()}
+	case !c.AllowDowngrade && to.Less(from):
+		return IncompatibleFirmwareError{DeviceType: device, Version: to, Reason: "downgrade from " + from.String()}
+	default:
+		return nil
+	}
+}
+
+func (t FirmwareCompatibilityTable) CheckFeature(device deviceType, v FirmwareVersion, feature string) error {
+	constraint, ok := t[device].Features[feature]
+
+	if !ok {
+		return IncompatibleFirmwareError{DeviceType: device, Version: v, Reason: "feature " + feature + " is unknown"}
+	}
+
+	if !constraint.Matches(v) {
+		return IncompatibleFirmwareError{DeviceType: device, Version: v, Reason: "feature " + feature + " requires " + constraint.String()}
+	}
+
+	return nil
+}
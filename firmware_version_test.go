@@ -0,0 +1,121 @@
+package messages
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestParseFirmwareVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		version FirmwareVersion
+		out     string
+	}{
+		{"1", FirmwareVersion{Numbers: [4]int{1}, Components: 1}, "1"},
+		{"v2.3", FirmwareVersion{Numbers: [4]int{2, 3}, Components: 2}, "2.3"},
+		{" 1.2.3.4 ", FirmwareVersion{Numbers: [4]int{1, 2, 3, 4}, Components: 4}, "1.2.3.4"},
+		{"2.0.0-rc.1+build.7", FirmwareVersion{Numbers: [4]int{2}, Components: 3, PreRelease: "rc.1", Metadata: "build.7"}, "2.0.0-rc.1+build.7"},
+	}
+
+	for _, tt := range tests {
+		v, err := ParseFirmwareVersion(tt.in)
+
+		if err != nil || v != tt.version || v.String() != tt.out {
+			t.Errorf("%q = %+v (%s), %v", tt.in, v, v, err)
+		}
+	}
+
+	for _, in := range []string{"", "1.2.3.4.5", "1..2", "1.x", "1.-2", "1.+2", "1.0-", "1.0+", "-1"} {
+		if _, err := ParseFirmwareVersion(in); err == nil {
+			t.Errorf("%q parsed", in)
+		} else if _, ok := err.(InvalidFirmwareVersion); !ok {
+			t.Errorf("%q: %v", in, err)
+		}
+	}
+}
+
+func TestFirmwareVersionCompare(t *testing.T) {
+	// Each version orders before the next one.
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.0.1", "1.2", "2"}
+
+	for i := 1; i < len(ordered); i++ {
+		a, b := MustParseFirmwareVersion(ordered[i-1]), MustParseFirmwareVersion(ordered[i])
+
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf("%s does not order before %s", a, b)
+		}
+	}
+
+	if MustParseFirmwareVersion("1.2+a").Compare(MustParseFirmwareVersion("1.2.0+b")) != 0 {
+		t.Error("metadata or missing components take part in ordering")
+	}
+}
+
+func TestFirmwareConstraintMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		matches    bool
+	}{
+		{">= 2.3.0, < 3", "2.3.0", true},
+		{">= 2.3.0, < 3", "2.10", true},
+		{">= 2.3.0, < 3", "3.0.0-rc.1", true},
+		{">= 2.3.0, < 3", "3.0.0", false},
+		{">= 2.3.0, < 3", "2.2.9", false},
+		{"1.4", "1.4.0", true},
+		{"=1.4", "1.4.1", false},
+		{"!= 1.4", "1.4.1", true},
+		{"> 1.4", "1.4.0", false},
+		{"<=1.4", "1.4.0-beta", true},
+	}
+
+	for _, tt := range tests {
+		c := MustParseFirmwareConstraint(tt.constraint)
+
+		if matches := c.Matches(MustParseFirmwareVersion(tt.version)); matches != tt.matches {
+			t.Errorf("%q matches %s = %v", tt.constraint, tt.version, matches)
+		}
+	}
+
+	for _, in := range []string{"", ">= 1,", ">= x", "~1.0"} {
+		if _, err := ParseFirmwareConstraint(in); err == nil {
+			t.Errorf("%q parsed", in)
+		} else if _, ok := err.(InvalidFirmwareConstraint); !ok {
+			t.Errorf("%q: %v", in, err)
+		}
+	}
+}
+
+func TestFirmwareCompatibilityTable(t *testing.T) {
+	var table FirmwareCompatibilityTable
+
+	err := json.Unmarshal([]byte(`{"FullCloudLock": {"targets": ">= 2", "sources": ">= 1.5", "features": {"meetingMode": ">= 2.1"}}}`), &table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := MustParseFirmwareVersion
+
+	tests := []struct {
+		device   deviceType
+		from, to string
+		ok       bool
+	}{
+		{DeviceTypeFCLock, "1.5", "2.0", true},
+		{DeviceTypeFCLock, "1.4", "2.0", false},
+		{DeviceTypeFCLock, "1.5", "1.9", false},
+		{DeviceTypeFCLock, "2.2", "2.1", false},
+		{DeviceTypeWallReader, "1.5", "2.0", false},
+	}
+
+	for _, tt := range tests {
+		if err := table.CheckUpgrade(tt.device, v(tt.from), v(tt.to)); (err == nil) != tt.ok {
+			t.Errorf("%s from %s to %s: %v", tt.device, tt.from, tt.to, err)
+		}
+	}
+
+	if table.CheckFeature(DeviceTypeFCLock, v("2.1"), "meetingMode") != nil || table.CheckFeature(DeviceTypeFCLock, v("2.0"), "meetingMode") == nil {
+		t.Error("meetingMode is not gated at 2.1")
+	}
+}
@@ -0,0 +1,264 @@
+package messages
+
+import (
+	"sort"
+	"time"
+)
+
+// FlashModel estimates how many bytes of key storage a record occupies on a device. The protocol does not document
+// the key storage layout, so the sizes come from the firmware vendor; no models are built in.
+type FlashModel struct {
+	Capacity      int
+	RecordSize    int
+	TimeKeySize   int
+	AclKeySize    int
+	ChannelIdSize int
+}
+
+func (m *FlashModel) Size(data *StorageData) int {
+	size := m.RecordSize + len(data.MasterKey.ChannelIds)*m.ChannelIdSize
+
+	for _, k := range data.TimeKeys {
+		size += m.TimeKeySize + len(k.ChannelIds)*m.ChannelIdSize
+	}
+
+	for _, k := range data.AclKeys {
+		size += m.AclKeySize + len(k.ChannelIds)*m.ChannelIdSize
+	}
+
+	return size
+}
+
+type FlashUsage struct {
+	ExtAddr  string
+	Used     int
+	Capacity int
+}
+
+func (u FlashUsage) Ratio() float64 {
+	if u.Capacity == 0 {
+		return 1
+	}
+
+	return float64(u.Used) / float64(u.Capacity)
+}
+
+// FlashMonitor models the key storage of every device from the records the Registry knows, using the model of
+// its device type from Models. Evictable selects records that may be deleted to make room once expired time keys
+// are not enough.
+type FlashMonitor struct {
+	Registry  *KeyRegistry
+	Models    map[deviceType]FlashModel
+	WarnRatio float64
+	OnWarning func(FlashUsage)
+	Evictable func(data *StorageData) bool
+	Now       func() time.Time
+}
+
+func (m *FlashMonitor) model(t deviceType) (FlashModel, error) {
+	model, ok := m.Models[t]
+	if !ok {
+		return FlashModel{}, FlashModelMissing{t}
+	}
+
+	return model, nil
+}
+
+func (m *FlashMonitor) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+
+	return time.Now()
+}
+
+func (m *FlashMonitor) Usage(extAddr string, t deviceType) (FlashUsage, error) {
+	model, err := m.model(t)
+
+	if err != nil {
+		return FlashUsage{}, err
+	}
+
+	usage := FlashUsage{ExtAddr: extAddr, Capacity: model.Capacity}
+
+	for _, data := range m.Registry.Keys(extAddr) {
+		usage.Used += model.Size(&data)
+	}
+
+	return usage, nil
+}
+
+// Check projects the usage after data is stored on the device. It fails with FlashFullError when the record
+// would not fit and reports a warning when the projected usage passes WarnRatio.
+func (m *FlashMonitor) Check(extAddr string, t deviceType, data *StorageData) (FlashUsage, error) {
+	model, err := m.model(t)
+
+	if err != nil {
+		return FlashUsage{}, err
+	}
+
+	usage, _ := m.Usage(extAddr, t)
+
+	if current, ok := m.Registry.Get(extAddr, data.HashKey); ok {
+		usage.Used -= model.Size(&current)
+	}
+
+	usage.Used += model.Size(data)
+
+	if usage.Used > usage.Capacity {
+		return usage, FlashFullError{ExtAddr: extAddr, Used: usage.Used, Capacity: usage.Capacity}
+	}
+
+	if m.WarnRatio > 0 && usage.Ratio() >= m.WarnRatio && m.OnWarning != nil {
+		m.OnWarning(usage)
+	}
+
+	return usage, nil
+}
+
+// EvictionPlan frees room for incoming after the device reported StorageResponseStatusErrorFlashStorageFull.
+// Expired time keys are dropped first, oldest first; records left without any key are deleted. Records chosen by
+// Evictable are deleted next. deviceClock is the current time of the device in its own zone.
+func (m *FlashMonitor) EvictionPlan(extAddr string, t deviceType, incoming *StorageData, deviceClock time.Time) (StoragePlan, error) {
+	model, err := m.model(t)
+
+	if err != nil {
+		return StoragePlan{}, err
+	}
+
+	usage, _ := m.Usage(extAddr, t)
+	need := usage.Used + model.Size(incoming) - usage.Capacity
+
+	if need <= 0 {
+		// The device disagrees with the model, so free at least the size of the incoming record.
+		need = model.Size(incoming)
+	}
+
+	plan := StoragePlan{CreatedAt: m.now()}
+	freed := 0
+	evicted := make(map[string]bool)
+
+	type expiredKey struct {
+		hashKey string
+		endTime int
+	}
+
+	var expired []expiredKey
+	for _, data := range m.Registry.Keys(extAddr) {
+		for _, k := range data.TimeKeys {
+			if k.Expired(deviceClock) {
+				expired = append(expired, expiredKey{data.HashKey, k.EndTime})
+			}
+		}
+	}
+
+	sort.SliceStable(expired, func(i, j int) bool { return expired[i].endTime < expired[j].endTime })
+
+	pruned := make(map[string]*StorageData)
+	for _, e := range expired {
+		if freed >= need {
+			break
+		}
+
+		if e.hashKey == incoming.HashKey {
+			continue
+		}
+
+		data, ok := pruned[e.hashKey]
+		if !ok {
+			current, _ := m.Registry.Get(extAddr, e.hashKey)
+			data = &current
+			pruned[e.hashKey] = data
+		}
+
+		for i, k := range data.TimeKeys {
+			if k.EndTime == e.endTime && k.Expired(deviceClock) {
+				freed += model.TimeKeySize + len(k.ChannelIds)*model.ChannelIdSize
+				data.TimeKeys = append(data.TimeKeys[:i:i], data.TimeKeys[i+1:]...)
+				break
+			}
+		}
+	}
+
+	hashKeys := make([]string, 0, len(pruned))
+	for hashKey := range pruned {
+		hashKeys = append(hashKeys, hashKey)
+	}
+
+	sort.Strings(hashKeys)
+
+	for _, hashKey := range hashKeys {
+		data := pruned[hashKey]
+		previous, _ := m.Registry.Get(extAddr, hashKey)
+
+		if data.Empty() {
+			freed += model.Size(data)
+			evicted[hashKey] = true
+			plan.Steps = append(plan.Steps, PlanStep{
+				ExtAddr:   extAddr,
+				Operation: PlanDelete,
+				HashKey:   hashKey,
+				Reason:    "all time keys expired",
+				Previous:  &previous,
+			})
+
+			continue
+		}
+
+		plan.Steps = append(plan.Steps, PlanStep{
+			ExtAddr:   extAddr,
+			Operation: PlanUpdate,
+			HashKey:   hashKey,
+			Reason:    "expired time keys removed",
+			Data:      data,
+			Previous:  &previous,
+		})
+	}
+
+	if m.Evictable != nil {
+		for _, data := range m.Registry.Keys(extAddr) {
+			if freed >= need {
+				break
+			}
+
+			if evicted[data.HashKey] || data.HashKey == incoming.HashKey || !m.Evictable(&data) {
+				continue
+			}
+
+			previous := data
+			size := model.Size(&data)
+
+			if p, ok := pruned[data.HashKey]; ok {
+				size = model.Size(p)
+				plan.Steps = removePlanStep(plan.Steps, data.HashKey)
+			}
+
+			freed += size
+			plan.Steps = append(plan.Steps, PlanStep{
+				ExtAddr:   extAddr,
+				Operation: PlanDelete,
+				HashKey:   data.HashKey,
+				Reason:    "evictable",
+				Previous:  &previous,
+			})
+		}
+	}
+
+	if freed < need {
+		return plan, FlashFullError{ExtAddr: extAddr, Used: usage.Used, Capacity: usage.Capacity, Missing: need - freed}
+	}
+
+	return plan, nil
+}
+
+func removePlanStep(steps []PlanStep, hashKey string) []PlanStep {
+	kept := steps[:0]
+
+	for _, step := range steps {
+		if step.HashKey != hashKey {
+			kept = append(kept, step)
+		}
+	}
+
+	return kept
+}
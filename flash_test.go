@@ -0,0 +1,92 @@
+package messages
+
+import (
+	"testing"
+	"time"
+)
+
+var testFlashModels = map[deviceType]FlashModel{
+	DeviceTypeFCLock: {Capacity: 100, RecordSize: 20, TimeKeySize: 10, AclKeySize: 5, ChannelIdSize: 1},
+}
+
+func TestFlashMonitorCheck(t *testing.T) {
+	registry := &KeyRegistry{}
+	registry.Put("lock", StorageData{HashKey: "a", MasterKey: MasterKey{ChannelIds: []int{1, 2}}})
+
+	var warned []FlashUsage
+	monitor := &FlashMonitor{Registry: registry, Models: testFlashModels, WarnRatio: 0.5, OnWarning: func(u FlashUsage) { warned = append(warned, u) }}
+
+	usage, err := monitor.Check("lock", DeviceTypeFCLock, &StorageData{HashKey: "b", TimeKeys: []TimeKey{{StartTime: 1, EndTime: 2}}})
+	if err != nil || usage.Used != 52 {
+		t.Fatalf("usage %+v, %v", usage, err)
+	}
+
+	if len(warned) != 1 {
+		t.Fatal("no warning past WarnRatio")
+	}
+
+	big := &StorageData{HashKey: "b", TimeKeys: make([]TimeKey, 8)}
+	if _, err = monitor.Check("lock", DeviceTypeFCLock, big); err == nil {
+		t.Fatal("a record that does not fit passed the check")
+	}
+
+	if _, err = monitor.Check("lock", DeviceTypeFCRelay, big); err != (FlashModelMissing{DeviceTypeFCRelay}) {
+		t.Fatalf("device type without a model: %v", err)
+	}
+}
+
+func TestFlashMonitorEvictionPlanDropsExpiredTimeKeys(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, _ := NewTimeKey(now.Add(-2*time.Hour), now.Add(-time.Hour), time.UTC)
+	future, _ := NewTimeKey(now.Add(-time.Hour), now.Add(time.Hour), time.UTC)
+
+	registry := &KeyRegistry{}
+	registry.Put("lock", StorageData{HashKey: "gone", TimeKeys: []TimeKey{past}})
+	registry.Put("lock", StorageData{HashKey: "mixed", TimeKeys: []TimeKey{past, future}})
+	registry.Put("lock", StorageData{HashKey: "master", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: []int{1}}})
+
+	monitor := &FlashMonitor{Registry: registry, Models: testFlashModels, Now: func() time.Time { return now }}
+
+	// 91 bytes are used; the incoming record of 30 bytes needs 21 of them.
+	plan, err := monitor.EvictionPlan("lock", DeviceTypeFCLock, &StorageData{HashKey: "new", TimeKeys: []TimeKey{future}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !plan.CreatedAt.Equal(now) || len(plan.Steps) != 2 {
+		t.Fatalf("plan %+v", plan)
+	}
+
+	if step := plan.Steps[0]; step.HashKey != "gone" || step.Operation != PlanDelete {
+		t.Fatalf("first step %+v", step)
+	}
+
+	if step := plan.Steps[1]; step.HashKey != "mixed" || step.Operation != PlanUpdate || len(step.Data.TimeKeys) != 1 || step.Data.TimeKeys[0].EndTime != future.EndTime {
+		t.Fatalf("second step %+v", step)
+	}
+}
+
+func TestFlashMonitorEvictionPlanFallsBackToEvictable(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	registry := &KeyRegistry{}
+	registry.Put("lock", StorageData{HashKey: "master", Flags: Flags{MasterKey: true}})
+	registry.Put("lock", StorageData{HashKey: "visitor", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: make([]int, 60)}})
+
+	monitor := &FlashMonitor{Registry: registry, Models: testFlashModels, Evictable: func(d *StorageData) bool { return d.HashKey == "visitor" }}
+
+	plan, err := monitor.EvictionPlan("lock", DeviceTypeFCLock, &StorageData{HashKey: "new", MasterKey: MasterKey{ChannelIds: make([]int, 10)}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plan.Steps) != 1 || plan.Steps[0].HashKey != "visitor" {
+		t.Fatalf("plan %+v", plan)
+	}
+
+	monitor.Evictable = nil
+
+	if _, err = monitor.EvictionPlan("lock", DeviceTypeFCLock, &StorageData{HashKey: "new", MasterKey: MasterKey{ChannelIds: make([]int, 10)}}, now); err == nil {
+		t.Fatal("a plan that frees too little reported no error")
+	}
+}
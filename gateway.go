@@ -2,6 +2,7 @@ package messages
 
 import (
 	"github.com/goccy/go-json"
+	"strconv"
 	"time"
 )
 
@@ -84,6 +85,11 @@ type Device struct {
 	SmartObjects struct{} `json:"smart_objects"`
 }
 
+func (d *Device) IsActive() bool {
+	active, _ := strconv.ParseBool(d.Active)
+	return active
+}
+
 type GetNetworkInfoResponse struct {
 	Name            string   `json:"name"`
 	Channels        int      `json:"channels"`
@@ -0,0 +1,356 @@
+package messages
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// ClosurePeriod groups closure dates that lie close enough to share one exception. From and Until are the device
+// wall clock bounds, encoded like TimeKey bounds, between which the exception replaces the regular data. They
+// start a day before the first closure and end two days after the last one, so windows crossing midnight into or
+// out of a closure are narrowed as well.
+type ClosurePeriod struct {
+	Closed []time.Time
+	From   time.Time
+	Until  time.Time
+}
+
+// Apply removes the weekdays of the period from every AclKey and grants the occurrences that do not touch a
+// closure date as TimeKeys instead. TimeKeys and the master key are explicit grants and are left as they are.
+func (p *ClosurePeriod) Apply(data StorageData) StorageData {
+	exception := data
+	exception.AclKeys = nil
+	exception.TimeKeys = append([]TimeKey(nil), data.TimeKeys...)
+
+	for _, k := range data.AclKeys {
+		startTime, endTime, err := k.Window()
+
+		if err != nil {
+			// A window that does not parse grants nothing the closure could take away.
+			exception.AclKeys = append(exception.AclKeys, k)
+			continue
+		}
+
+		days := k.Days()
+
+		for day := p.From; day.Before(p.Until); day = day.AddDate(0, 0, 1) {
+			if !k.Days().Contains(day.Weekday()) {
+				continue
+			}
+
+			days = days.Without(day.Weekday())
+
+			start, end := startTime.On(day), endTime.On(day)
+			if k.Overnight() {
+				end = end.AddDate(0, 0, 1)
+			}
+
+			for _, open := range p.open(start, end) {
+				exception.TimeKeys = append(exception.TimeKeys, TimeKey{
+					StartTime:  int(open[0].Unix()),
+					EndTime:    int(open[1].Unix()),
+					ChannelIds: k.ChannelIds,
+				})
+			}
+		}
+
+		if days != 0 {
+			k.SetDays(days)
+			exception.AclKeys = append(exception.AclKeys, k)
+		}
+	}
+
+	return exception
+}
+
+// open returns the parts of [start, end) that fall outside every closure date.
+func (p *ClosurePeriod) open(start, end time.Time) [][2]time.Time {
+	var parts [][2]time.Time
+
+	for _, closed := range p.Closed {
+		reopen := closed.AddDate(0, 0, 1)
+
+		if !reopen.After(start) || !closed.Before(end) {
+			continue
+		}
+
+		if closed.After(start) {
+			parts = append(parts, [2]time.Time{start, closed})
+		}
+
+		start = reopen
+	}
+
+	if start.Before(end) {
+		parts = append(parts, [2]time.Time{start, end})
+	}
+
+	return parts
+}
+
+// HolidayPush is an update due at At, a device wall clock moment encoded like TimeKey bounds.
+type HolidayPush struct {
+	ExtAddr string      `json:"extAddr"`
+	HashKey string      `json:"hashKey"`
+	At      time.Time   `json:"at"`
+	Data    StorageData `json:"data"`
+}
+
+type HolidayCalendar struct {
+	periods []ClosurePeriod
+}
+
+// NewHolidayCalendar takes closure dates as the calendar date of each value in its own location.
+func NewHolidayCalendar(closures ...time.Time) *HolidayCalendar {
+	dates := make([]time.Time, 0, len(closures))
+
+	for _, c := range closures {
+		y, m, d := c.Date()
+		dates = append(dates, time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	c := &HolidayCalendar{}
+
+	for _, date := range dates {
+		if n := len(c.periods); n > 0 {
+			last := &c.periods[n-1]
+
+			if date.Equal(last.Closed[len(last.Closed)-1]) {
+				continue
+			}
+
+			if date.AddDate(0, 0, -1).Before(last.Until) {
+				last.Closed = append(last.Closed, date)
+				last.Until = date.AddDate(0, 0, 2)
+				continue
+			}
+		}
+
+		c.periods = append(c.periods, ClosurePeriod{
+			Closed: []time.Time{date},
+			From:   date.AddDate(0, 0, -1),
+			Until:  date.AddDate(0, 0, 2),
+		})
+	}
+
+	return c
+}
+
+func (c *HolidayCalendar) Periods() []ClosurePeriod {
+	return c.periods
+}
+
+func (c *HolidayCalendar) Period(deviceClock time.Time) (ClosurePeriod, bool) {
+	now := time.Unix(deviceSeconds(deviceClock, deviceClock.Location()), 0).UTC()
+
+	for _, p := range c.periods {
+		if !now.Before(p.From) && now.Before(p.Until) {
+			return p, true
+		}
+	}
+
+	return ClosurePeriod{}, false
+}
+
+// Effective returns the data a device should hold at deviceClock. Expired TimeKeys are left out, as the KeySweeper
+// removes them from devices anyway.
+func (c *HolidayCalendar) Effective(data StorageData, deviceClock time.Time) StorageData {
+	if p, ok := c.Period(deviceClock); ok {
+		data = p.Apply(data)
+	}
+
+	if len(data.TimeKeys) == 0 {
+		return data
+	}
+
+	kept := make([]TimeKey, 0, len(data.TimeKeys))
+
+	for _, k := range data.TimeKeys {
+		if !k.Expired(deviceClock) {
+			kept = append(kept, k)
+		}
+	}
+
+	data.TimeKeys = kept
+
+	return data
+}
+
+// Pushes lists the updates due after deviceClock: the exception when a period starts and the regular data when
+// it ends. Periods that leave the data unchanged are skipped.
+func (c *HolidayCalendar) Pushes(extAddr string, data StorageData, deviceClock time.Time) []HolidayPush {
+	var pushes []HolidayPush
+
+	now := time.Unix(deviceSeconds(deviceClock, deviceClock.Location()), 0).UTC()
+
+	for _, p := range c.periods {
+		if !p.Until.After(now) {
+			continue
+		}
+
+		exception := p.Apply(data)
+
+		if exception.Equal(&data) {
+			continue
+		}
+
+		if p.From.After(now) {
+			pushes = append(pushes, HolidayPush{ExtAddr: extAddr, HashKey: data.HashKey, At: p.From, Data: exception})
+		}
+
+		pushes = append(pushes, HolidayPush{ExtAddr: extAddr, HashKey: data.HashKey, At: p.Until, Data: data})
+	}
+
+	return pushes
+}
+
+// HolidayScheduler keeps the records of the Registry in line with the Desired records and the Calendar. Desired
+// holds the regular data of every credential, Registry what the devices were last confirmed to hold. Records
+// missing from the Registry are left to the KeyReconciler.
+type HolidayScheduler struct {
+	Storage   *StorageClient
+	Calendar  *HolidayCalendar
+	Desired   *KeyRegistry
+	Registry  *KeyRegistry
+	Directory DeviceDirectory
+	Location  func(extAddr string) *time.Location
+	Interval  time.Duration
+	OnPush    func(HolidayPush, error)
+	Now       func() time.Time
+}
+
+func (s *HolidayScheduler) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+
+	return time.Now()
+}
+
+func (s *HolidayScheduler) interval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+
+	return DefaultSweepInterval
+}
+
+func (s *HolidayScheduler) location(extAddr string) *time.Location {
+	if s.Location != nil {
+		if loc := s.Location(extAddr); loc != nil {
+			return loc
+		}
+	}
+
+	return time.UTC
+}
+
+// Schedule lists the upcoming pushes of every device, earliest first.
+func (s *HolidayScheduler) Schedule() []HolidayPush {
+	var pushes []HolidayPush
+
+	for _, extAddr := range s.Desired.Devices() {
+		clock := s.now().In(s.location(extAddr))
+
+		for _, data := range s.Desired.Keys(extAddr) {
+			pushes = append(pushes, s.Calendar.Pushes(extAddr, data, clock)...)
+		}
+	}
+
+	sort.SliceStable(pushes, func(i, j int) bool { return s.due(pushes[i]).Before(s.due(pushes[j])) })
+
+	return pushes
+}
+
+func (s *HolidayScheduler) due(p HolidayPush) time.Time {
+	return deviceTime(p.At.Unix(), s.location(p.ExtAddr))
+}
+
+// Apply pushes every record whose device holds something other than its effective data right now.
+func (s *HolidayScheduler) Apply(ctx context.Context) ([]HolidayPush, error) {
+	var pushes []HolidayPush
+	var failed []string
+
+	for _, extAddr := range s.Desired.Devices() {
+		if s.Directory != nil && !s.Directory.Online(extAddr) {
+			continue
+		}
+
+		clock := s.now().In(s.location(extAddr))
+
+		for _, desired := range s.Desired.Keys(extAddr) {
+			current, ok := s.Registry.Get(extAddr, desired.HashKey)
+
+			if !ok {
+				continue
+			}
+
+			effective := s.Calendar.Effective(desired, clock)
+
+			if current.Equal(&effective) {
+				continue
+			}
+
+			push := HolidayPush{
+				ExtAddr: extAddr,
+				HashKey: desired.HashKey,
+				At:      time.Unix(deviceSeconds(clock, clock.Location()), 0).UTC(),
+				Data:    effective,
+			}
+
+			_, err := s.Storage.UpdateKey(ctx, extAddr, effective)
+
+			if err == nil {
+				s.Registry.Put(extAddr, effective)
+			} else {
+				failed = append(failed, desired.HashKey)
+			}
+
+			pushes = append(pushes, push)
+
+			if s.OnPush != nil {
+				s.OnPush(push, err)
+			}
+
+			if ctx.Err() != nil {
+				return pushes, ctx.Err()
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return pushes, HolidayPushError{HashKeys: failed}
+	}
+
+	return pushes, nil
+}
+
+// Run applies the calendar every Interval and when the next push is due, whichever comes first.
+func (s *HolidayScheduler) Run(ctx context.Context) error {
+	for {
+		if _, err := s.Apply(ctx); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		wait := s.interval()
+
+		if pushes := s.Schedule(); len(pushes) > 0 {
+			// A push due already failed in Apply and waits for the next interval like any other failure.
+			if next := s.due(pushes[0]).Sub(s.now()); next > 0 && next < wait {
+				wait = next
+			}
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package messages
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHolidaySchedulerRunsWithoutInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	applied := 0
+	scheduler := &HolidayScheduler{
+		Storage:  &StorageClient{Transport: &fakeStorage{}},
+		Calendar: NewHolidayCalendar(),
+		Desired:  &KeyRegistry{},
+		Registry: &KeyRegistry{},
+		Now:      func() time.Time { applied++; return time.Now() },
+	}
+
+	scheduler.Desired.Put("lock", StorageData{HashKey: "key"})
+
+	if err := scheduler.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+
+	if applied > 10 {
+		t.Fatalf("the scheduler looped %d times without an interval", applied)
+	}
+}
+
+func TestHolidaySchedulerClosesAndReopens(t *testing.T) {
+	ctx := context.Background()
+
+	opening, _ := NewTimeOfDay(8, 0, 0)
+	closing, _ := NewTimeOfDay(18, 0, 0)
+
+	regular := StorageData{HashKey: "key", AclKeys: []AclKey{NewAclKey(AllWeekdays, opening, closing, []int{1})}}
+
+	device := &fakeStorage{}
+	device.put("lock", regular)
+
+	now := time.Date(2024, 12, 25, 12, 0, 0, 0, time.UTC)
+	scheduler := &HolidayScheduler{
+		Storage:  &StorageClient{Transport: device},
+		Calendar: NewHolidayCalendar(time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC)),
+		Desired:  &KeyRegistry{},
+		Registry: &KeyRegistry{},
+		// The zone of the lock is unknown, so it is taken to run on UTC.
+		Location: func(string) *time.Location { return nil },
+		Now:      func() time.Time { return now },
+	}
+
+	scheduler.Desired.Put("lock", regular)
+	scheduler.Registry.Put("lock", regular)
+
+	if _, err := scheduler.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	closed, _ := device.key("lock", "key")
+
+	if len(closed.AclKeys) != 1 || closed.AclKeys[0].Days().Contains(time.Wednesday) {
+		t.Fatalf("acl keys during the closure %+v", closed.AclKeys)
+	}
+
+	for _, k := range closed.TimeKeys {
+		if k.Active(now) {
+			t.Fatalf("time key %+v opens the lock on the closure date", k)
+		}
+	}
+
+	// Only the day after the closure is left, the grant of the day before has expired by now.
+	if len(closed.TimeKeys) != 1 {
+		t.Fatalf("time keys during the closure %+v", closed.TimeKeys)
+	}
+
+	now = time.Date(2024, 12, 27, 12, 0, 0, 0, time.UTC)
+
+	if _, err := scheduler.Apply(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if reopened, _ := device.key("lock", "key"); !reopened.Equal(&regular) {
+		t.Fatalf("data after the closure %+v", reopened)
+	}
+}
@@ -0,0 +1,505 @@
+package messages
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type UnsupportedCalendarRule struct {
+	Line    int
+	UID     string
+	Summary string
+	Reason  string
+}
+
+type CalendarImport struct {
+	Policy      Policy
+	Unsupported []UnsupportedCalendarRule
+}
+
+// CalendarImporter converts RFC 5545 VEVENTs into keys for devices in Location. Weekly and daily recurrences become
+// AclKeys, single events become TimeKeys. Anything a device cannot represent exactly is reported, not approximated,
+// and so are cancelled events. Without Location the devices are taken to run on UTC. Now decides which recurrences
+// have started; it defaults to time.Now.
+type CalendarImporter struct {
+	Location   *time.Location
+	ChannelIds []int
+	Now        func() time.Time
+}
+
+type calendarProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+type calendarEvent struct {
+	line       int
+	properties map[string][]calendarProperty
+}
+
+func (e *calendarEvent) get(name string) (calendarProperty, bool) {
+	p, ok := e.properties[name]
+	if !ok {
+		return calendarProperty{}, false
+	}
+
+	return p[0], true
+}
+
+func (c *CalendarImporter) ImportFile(path string) (CalendarImport, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return CalendarImport{}, err
+	}
+
+	defer file.Close()
+
+	return c.Import(file)
+}
+
+func (c *CalendarImporter) Import(r io.Reader) (CalendarImport, error) {
+	var result CalendarImport
+
+	events, err := readCalendarEvents(r)
+
+	if err != nil {
+		return result, err
+	}
+
+	importer := *c
+	if importer.Location == nil {
+		importer.Location = time.UTC
+	}
+
+	for i := range events {
+		if err := importer.event(&events[i], &result.Policy); err != nil {
+			e := &events[i]
+			uid, _ := e.get("UID")
+			summary, _ := e.get("SUMMARY")
+
+			result.Unsupported = append(result.Unsupported, UnsupportedCalendarRule{
+				Line:    e.line,
+				UID:     uid.value,
+				Summary: summary.value,
+				Reason:  err.Error(),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func readCalendarEvents(r io.Reader) ([]calendarEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	var numbers []int
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		if line != "" {
+			lines = append(lines, line)
+			numbers = append(numbers, n)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var current *calendarEvent
+	var nested []string
+
+	for i, line := range lines {
+		p, ok := parseCalendarProperty(line)
+
+		if !ok {
+			return nil, CalendarSyntaxError{Line: numbers[i], Reason: "missing \":\" in " + strconv.Quote(line)}
+		}
+
+		switch {
+		case p.name == "BEGIN" && current == nil && strings.EqualFold(p.value, "VEVENT"):
+			current = &calendarEvent{line: numbers[i], properties: make(map[string][]calendarProperty)}
+		case p.name == "BEGIN" && current != nil:
+			nested = append(nested, strings.ToUpper(p.value))
+		case p.name == "END" && current != nil && len(nested) > 0:
+			nested = nested[:len(nested)-1]
+		case p.name == "END" && current != nil:
+			events = append(events, *current)
+			current = nil
+		case current != nil && len(nested) == 0:
+			current.properties[p.name] = append(current.properties[p.name], p)
+		}
+	}
+
+	if current != nil {
+		return nil, CalendarSyntaxError{Line: current.line, Reason: "VEVENT is not terminated"}
+	}
+
+	return events, nil
+}
+
+func parseCalendarProperty(line string) (calendarProperty, bool) {
+	p := calendarProperty{params: make(map[string]string)}
+
+	quoted := false
+	colon := -1
+
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	if colon < 0 {
+		return p, false
+	}
+
+	p.value = line[colon+1:]
+	parts := strings.Split(line[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+
+		if len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], "\"")
+		}
+	}
+
+	return p, true
+}
+
+func (c *CalendarImporter) event(e *calendarEvent, policy *Policy) error {
+	if status, ok := e.get("STATUS"); ok && strings.EqualFold(status.value, "CANCELLED") {
+		return CalendarRuleError{"event is cancelled"}
+	}
+
+	for _, name := range [...]string{"RDATE", "EXDATE", "EXRULE"} {
+		if _, ok := e.get(name); ok {
+			return CalendarRuleError{name + " is not supported"}
+		}
+	}
+
+	if len(e.properties["RRULE"]) > 1 {
+		return CalendarRuleError{"multiple RRULEs are not supported"}
+	}
+
+	dtstart, ok := e.get("DTSTART")
+	if !ok {
+		return CalendarRuleError{"DTSTART is missing"}
+	}
+
+	start, allDay, err := parseCalendarTime(dtstart, c.Location)
+
+	if err != nil {
+		return err
+	}
+
+	end, err := c.end(e, start, allDay)
+
+	if err != nil {
+		return err
+	}
+
+	if !end.After(start) {
+		return CalendarRuleError{"event ends before it starts"}
+	}
+
+	rrule, recurring := e.get("RRULE")
+
+	if !recurring {
+		k, err := NewTimeKey(start, end, c.Location, c.ChannelIds...)
+
+		if err != nil {
+			return err
+		}
+
+		policy.TimeKeys = append(policy.TimeKeys, k)
+
+		return nil
+	}
+
+	return c.recurrence(rrule.value, start, end, policy)
+}
+
+func (c *CalendarImporter) end(e *calendarEvent, start time.Time, allDay bool) (time.Time, error) {
+	if dtend, ok := e.get("DTEND"); ok {
+		end, _, err := parseCalendarTime(dtend, c.Location)
+		return end, err
+	}
+
+	if duration, ok := e.get("DURATION"); ok {
+		d, err := parseCalendarDuration(duration.value)
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return start.Add(d), nil
+	}
+
+	if allDay {
+		return start.AddDate(0, 0, 1), nil
+	}
+
+	return time.Time{}, CalendarRuleError{"DTEND or DURATION is missing"}
+}
+
+func (c *CalendarImporter) recurrence(rule string, start, end time.Time, policy *Policy) error {
+	var days WeekdaySet
+	var until time.Time
+
+	frequency := ""
+
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+
+		if len(kv) != 2 {
+			return CalendarRuleError{"malformed RRULE part " + strconv.Quote(part)}
+		}
+
+		switch value := strings.ToUpper(kv[1]); strings.ToUpper(kv[0]) {
+		case "FREQ":
+			frequency = value
+		case "INTERVAL":
+			if value != "1" {
+				return CalendarRuleError{"INTERVAL other than 1 is not supported"}
+			}
+		case "WKST":
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				d, ok := parseCalendarWeekday(day)
+
+				if !ok {
+					return CalendarRuleError{"BYDAY " + day + " is not supported"}
+				}
+
+				days = days.With(d)
+			}
+		case "UNTIL":
+			u, _, err := parseCalendarTime(calendarProperty{value: value}, start.Location())
+
+			if err != nil {
+				return err
+			}
+
+			until = u
+		default:
+			return CalendarRuleError{kv[0] + " is not supported"}
+		}
+	}
+
+	switch frequency {
+	case "WEEKLY":
+		if days == 0 {
+			days = days.With(start.Weekday())
+		}
+	case "DAILY":
+		if days == 0 {
+			days = AllWeekdays
+		}
+	default:
+		return CalendarRuleError{"FREQ=" + frequency + " is not supported"}
+	}
+
+	if end.Sub(start) > 24*time.Hour {
+		return CalendarRuleError{"recurring events longer than a day are not supported"}
+	}
+
+	now := time.Now()
+	if c.Now != nil {
+		now = c.Now()
+	}
+
+	// An AclKey has no start date, so a recurrence that has not started yet would open the lock from today on.
+	if start.After(now) {
+		return CalendarRuleError{"recurrences starting in the future are not supported"}
+	}
+
+	if err := c.sameOffsets(start); err != nil {
+		return err
+	}
+
+	local := start.In(c.Location)
+	shift := (int(local.Weekday()) - int(start.Weekday()) + 7) % 7
+
+	var deviceDays WeekdaySet
+
+	for _, d := range days.Days() {
+		deviceDays = deviceDays.With((d + time.Weekday(shift)) % 7)
+	}
+
+	startTime, endTime := TimeOfDayOf(local), TimeOfDayOf(end.In(c.Location))
+
+	if endTime == 0 && end.Sub(start) > 0 {
+		endTime = EndOfDay
+	}
+
+	k := NewAclKey(deviceDays, startTime, endTime, c.ChannelIds)
+
+	if err := k.Validate(); err != nil {
+		return err
+	}
+
+	policy.AclKeys = append(policy.AclKeys, k)
+
+	if !until.IsZero() || len(policy.AclUntil) > 0 {
+		for len(policy.AclUntil) < len(policy.AclKeys)-1 {
+			policy.AclUntil = append(policy.AclUntil, time.Time{})
+		}
+
+		var bound time.Time
+		if !until.IsZero() {
+			// UNTIL bounds the start of the last occurrence, which still lasts its full duration.
+			last := startTime.On(until.In(c.Location))
+			if last.After(until) {
+				last = last.AddDate(0, 0, -1)
+			}
+
+			bound = time.Unix(deviceSeconds(last.Add(end.Sub(start)), c.Location), 0).UTC()
+		}
+
+		policy.AclUntil = append(policy.AclUntil, bound)
+	}
+
+	return nil
+}
+
+// sameOffsets rejects events whose wall clock drifts against the device, e.g. across daylight saving changes.
+func (c *CalendarImporter) sameOffsets(start time.Time) error {
+	_, event := start.Zone()
+	_, device := start.In(c.Location).Zone()
+
+	for day := 1; day <= 366; day++ {
+		probe := start.AddDate(0, 0, day)
+		_, e := probe.Zone()
+		_, d := probe.In(c.Location).Zone()
+
+		if e-d != event-device {
+			return CalendarRuleError{"time zone of the event and the device differ across the year"}
+		}
+	}
+
+	return nil
+}
+
+// Floating times and dates are taken to be on the device wall clock.
+func parseCalendarTime(p calendarProperty, loc *time.Location) (time.Time, bool, error) {
+	value := strings.ToUpper(p.value)
+
+	if p.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, loc)
+
+		if err != nil {
+			return t, true, CalendarRuleError{"invalid date " + strconv.Quote(p.value)}
+		}
+
+		return t, true, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	} else if tzid, ok := p.params["TZID"]; ok {
+		var err error
+
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, CalendarRuleError{"unknown time zone " + strconv.Quote(tzid)}
+		}
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+
+	if err != nil {
+		return t, false, CalendarRuleError{"invalid date-time " + strconv.Quote(p.value)}
+	}
+
+	return t, false, nil
+}
+
+func parseCalendarDuration(value string) (time.Duration, error) {
+	invalid := CalendarRuleError{"invalid DURATION " + strconv.Quote(value)}
+	value = strings.ToUpper(value)
+
+	if !strings.HasPrefix(value, "P") {
+		return 0, invalid
+	}
+
+	var d time.Duration
+	var number int
+	var digits bool
+
+	inTime := false
+	units := map[bool]map[byte]time.Duration{
+		false: {'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour},
+		true:  {'H': time.Hour, 'M': time.Minute, 'S': time.Second},
+	}
+
+	for i := 1; i < len(value); i++ {
+		ch := value[i]
+
+		switch {
+		case ch >= '0' && ch <= '9':
+			number = number*10 + int(ch-'0')
+			digits = true
+		case ch == 'T' && !inTime && !digits:
+			inTime = true
+		default:
+			unit, ok := units[inTime][ch]
+
+			if !ok || !digits {
+				return 0, invalid
+			}
+
+			d += time.Duration(number) * unit
+			number, digits = 0, false
+		}
+	}
+
+	if digits {
+		return 0, invalid
+	}
+
+	return d, nil
+}
+
+func parseCalendarWeekday(s string) (time.Weekday, bool) {
+	switch s {
+	case "SU":
+		return time.Sunday, true
+	case "MO":
+		return time.Monday, true
+	case "TU":
+		return time.Tuesday, true
+	case "WE":
+		return time.Wednesday, true
+	case "TH":
+		return time.Thursday, true
+	case "FR":
+		return time.Friday, true
+	case "SA":
+		return time.Saturday, true
+	default:
+		return 0, false
+	}
+}
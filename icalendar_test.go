@@ -0,0 +1,181 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarImporterDefaultsAndCancelledEvents(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:meeting",
+		"DTSTART:20240301T090000Z",
+		"DTEND:20240301T100000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:cancelled",
+		"STATUS:CANCELLED",
+		"DTSTART:20240302T090000Z",
+		"DTEND:20240302T100000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	result, err := (&CalendarImporter{}).Import(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Policy.TimeKeys) != 1 {
+		t.Fatalf("imported %d time keys, want 1", len(result.Policy.TimeKeys))
+	}
+
+	if start := result.Policy.TimeKeys[0].Start(time.UTC); !start.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("start = %v", start)
+	}
+
+	if len(result.Unsupported) != 1 || result.Unsupported[0].UID != "cancelled" {
+		t.Fatalf("unsupported = %+v", result.Unsupported)
+	}
+}
+
+func TestCalendarImporterRecurrences(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:cleaning",
+		"DTSTART:20240304T230000Z",
+		"DTEND:20240304T233000Z",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240401T235959Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:office",
+		"DTSTART;TZID=Asia/Tokyo:20240301T090000",
+		"DURATION:PT9H",
+		"RRULE:FREQ=DAILY",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:fortnightly",
+		"DTSTART:20240304T090000Z",
+		"DTEND:20240304T100000Z",
+		"RRULE:FREQ=WEEKLY;INTERVAL=2",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:next-term",
+		"DTSTART:20240902T090000Z",
+		"DTEND:20240902T100000Z",
+		"RRULE:FREQ=WEEKLY",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	importer := &CalendarImporter{
+		Location:   time.FixedZone("JST", 9*60*60),
+		ChannelIds: []int{1},
+		Now:        func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	result, err := importer.Import(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Policy.AclKeys) != 2 {
+		t.Fatalf("imported %d acl keys, want 2", len(result.Policy.AclKeys))
+	}
+
+	// Monday and Wednesday 23:00 UTC are Tuesday and Thursday 08:00 on the device.
+	tests := []struct {
+		days       WeekdaySet
+		start, end string
+		until      time.Time
+	}{
+		{WeekdaySet(0).With(time.Tuesday).With(time.Thursday), "08:00", "08:30", time.Date(2024, 4, 2, 8, 30, 0, 0, time.UTC)},
+		{AllWeekdays, "09:00", "18:00", time.Time{}},
+	}
+
+	for i, tt := range tests {
+		k := result.Policy.AclKeys[i]
+		start, end, err := k.Window()
+
+		if err != nil || k.Days() != tt.days || start.String() != tt.start || end.String() != tt.end {
+			t.Errorf("acl key %d: %v %v-%v, %v", i, k.Days(), start, end, err)
+		}
+
+		if !result.Policy.AclUntil[i].Equal(tt.until) {
+			t.Errorf("acl key %d until %v, want %v", i, result.Policy.AclUntil[i], tt.until)
+		}
+	}
+
+	reasons := map[string]string{
+		"fortnightly": "INTERVAL other than 1 is not supported",
+		"next-term":   "recurrences starting in the future are not supported",
+	}
+
+	if len(result.Unsupported) != len(reasons) {
+		t.Fatalf("unsupported = %+v", result.Unsupported)
+	}
+
+	for _, u := range result.Unsupported {
+		if reasons[u.UID] != u.Reason {
+			t.Errorf("%s rejected as %q", u.UID, u.Reason)
+		}
+	}
+}
+
+func TestCalendarImporterEventsAndDailyRecurrence(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:visit",
+		"DTSTART:20240301T090000Z",
+		"DURATION:PT1H30M",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:office",
+		"DTSTART:20240301T080000Z",
+		"DTEND:20240301T170000Z",
+		"RRULE:FREQ=DAILY",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	importer := &CalendarImporter{
+		Location:   time.UTC,
+		ChannelIds: []int{1},
+		Now:        func() time.Time { return time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC) },
+	}
+
+	result, err := importer.Import(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Unsupported) != 0 {
+		t.Fatalf("unsupported = %+v", result.Unsupported)
+	}
+
+	if len(result.Policy.TimeKeys) != 1 {
+		t.Fatalf("imported %d time keys, want 1", len(result.Policy.TimeKeys))
+	}
+
+	k := result.Policy.TimeKeys[0]
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	if !k.Start(time.UTC).Equal(start) || !k.End(time.UTC).Equal(start.Add(90*time.Minute)) {
+		t.Fatalf("time key %v-%v", k.Start(time.UTC), k.End(time.UTC))
+	}
+
+	if len(result.Policy.AclKeys) != 1 {
+		t.Fatalf("imported %d acl keys, want 1", len(result.Policy.AclKeys))
+	}
+
+	acl := result.Policy.AclKeys[0]
+	from, until, err := acl.Window()
+
+	if err != nil || acl.Days() != AllWeekdays || from.String() != "08:00" || until.String() != "17:00" {
+		t.Fatalf("acl key %v %v-%v, %v", acl.Days(), from, until, err)
+	}
+}
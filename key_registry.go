@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"sort"
+	"sync"
+)
+
+// KeyRegistry remembers the StorageData records last confirmed on every device.
+type KeyRegistry struct {
+	mu      sync.RWMutex
+	devices map[string]map[string]StorageData
+}
+
+func (r *KeyRegistry) Put(extAddr string, data StorageData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.devices == nil {
+		r.devices = make(map[string]map[string]StorageData)
+	}
+
+	if r.devices[extAddr] == nil {
+		r.devices[extAddr] = make(map[string]StorageData)
+	}
+
+	data.Status = StorageResponseStatusOk
+	r.devices[extAddr][data.HashKey] = data
+}
+
+func (r *KeyRegistry) Remove(extAddr, hashKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.devices[extAddr], hashKey)
+
+	if len(r.devices[extAddr]) == 0 {
+		delete(r.devices, extAddr)
+	}
+}
+
+func (r *KeyRegistry) Get(extAddr, hashKey string) (StorageData, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	data, ok := r.devices[extAddr][hashKey]
+	return data, ok
+}
+
+func (r *KeyRegistry) Keys(extAddr string) []StorageData {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]StorageData, 0, len(r.devices[extAddr]))
+	for _, data := range r.devices[extAddr] {
+		keys = append(keys, data)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].HashKey < keys[j].HashKey })
+
+	return keys
+}
+
+func (r *KeyRegistry) Devices() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices := make([]string, 0, len(r.devices))
+	for extAddr := range r.devices {
+		devices = append(devices, extAddr)
+	}
+
+	sort.Strings(devices)
+
+	return devices
+}
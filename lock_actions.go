@@ -40,7 +40,7 @@ func (s *lockStatus) UnmarshalJSON(bytes []byte) (err error) {
 	defer func() {
 		if s != nil {
 			switch *s {
-			case NoneLockStatus, ExtRelayStateLockStatus, LockOpenedLockStatus, LockClosedLockStatus, DriverOnLockStatus, ErrorLockAlreadyOpenLockStatus, ErrorLockAlreadyClosedLockStatus, ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus:
+			case NoneLockStatus, ExtRelayStateLockStatus, LockOpenedLockStatus, LockClosedLockStatus, DriverOnLockStatus, ErrorLockAlreadyOpenLockStatus, ErrorLockAlreadyClosedLockStatus, ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus, OpenTimeoutLockStatus:
 			default:
 				err = InvalidLockStatus{*s}
 			}
@@ -54,7 +54,7 @@ func (s *lockStatus) UnmarshalJSON(bytes []byte) (err error) {
 func (s *lockStatus) MarshalJSON() ([]byte, error) {
 	if s != nil {
 		switch *s {
-		case NoneLockStatus, ExtRelayStateLockStatus, LockOpenedLockStatus, LockClosedLockStatus, DriverOnLockStatus, ErrorLockAlreadyOpenLockStatus, ErrorLockAlreadyClosedLockStatus, ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus:
+		case NoneLockStatus, ExtRelayStateLockStatus, LockOpenedLockStatus, LockClosedLockStatus, DriverOnLockStatus, ErrorLockAlreadyOpenLockStatus, ErrorLockAlreadyClosedLockStatus, ErrorDriverEnabledLockStatus, DeviceTypeUnknownLockStatus, OpenTimeoutLockStatus:
 		default:
 			return nil, InvalidLockStatus{*s}
 		}
@@ -0,0 +1,237 @@
+package messages
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+// readJSONFile decodes the file at path into v. A missing file leaves v untouched and reports false.
+func readJSONFile(path string, v interface{}) (bool, error) {
+	bytes, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(bytes, v)
+}
+
+// writeJSONFile replaces the file at path with v. It writes a temporary file first, so path always holds a
+// complete state.
+func writeJSONFile(path string, v interface{}) error {
+	bytes, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, bytes, 0600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := ioutil.WriteFile(path+".tmp", data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// recordFile keeps records by key as one JSON object in a file, rewritten on every change.
+type recordFile struct {
+	mu sync.Mutex
+}
+
+func (f *recordFile) read(path string) (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage)
+
+	if _, err := readJSONFile(path, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// load calls fn with every record in the file at path.
+func (f *recordFile) load(path string, fn func(record []byte) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records, err := f.read(path)
+
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *recordFile) put(path, key string, record interface{}) error {
+	bytes, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records, err := f.read(path)
+
+	if err != nil {
+		return err
+	}
+
+	records[key] = bytes
+
+	return writeJSONFile(path, records)
+}
+
+func (f *recordFile) delete(path, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	records, err := f.read(path)
+
+	if err != nil {
+		return err
+	}
+
+	if _, ok := records[key]; !ok {
+		return nil
+	}
+
+	delete(records, key)
+
+	return writeJSONFile(path, records)
+}
+
+// lineFile is an append-only file of newline delimited JSON records.
+type lineFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// openLineFile opens the file at path for appending. A last line without its newline is a write that was
+// interrupted and never acknowledged; it is cut off, so the next record does not end up glued to it.
+func openLineFile(path string) (*lineFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0640)
+
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := lastLineEnd(file)
+
+	if err == nil {
+		err = file.Truncate(end)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &lineFile{path: path, file: file}, nil
+}
+
+// lastLineEnd returns the offset just after the last newline of file.
+func lastLineEnd(file *os.File) (int64, error) {
+	info, err := file.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	chunk := make([]byte, 4096)
+
+	for end := info.Size(); end > 0; {
+		start := end - int64(len(chunk))
+		if start < 0 {
+			start = 0
+		}
+
+		n, err := file.ReadAt(chunk[:end-start], start)
+
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		if i := bytes.LastIndexByte(chunk[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+
+		end = start
+	}
+
+	return 0, nil
+}
+
+func (f *lineFile) append(record interface{}) error {
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err = f.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.file.Sync()
+}
+
+// scan calls fn with every complete line, oldest first, until fn returns false.
+func (f *lineFile) scan(fn func(line []byte) (bool, error)) error {
+	file, err := os.Open(f.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if next, err := fn(line); err != nil || !next {
+			return err
+		}
+	}
+}
+
+func (f *lineFile) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
@@ -0,0 +1,217 @@
+package messages
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	PlanDelete planOperation = "delete"
+	PlanUpdate planOperation = "update"
+	PlanAdd    planOperation = "add"
+)
+
+type planOperation string
+
+func (o planOperation) order() int {
+	switch o {
+	case PlanDelete:
+		return 0
+	case PlanUpdate:
+		return 1
+	default:
+		return 2
+	}
+}
+
+type PlanStep struct {
+	ExtAddr   string        `json:"extAddr"`
+	Operation planOperation `json:"operation"`
+	HashKey   string        `json:"hashKey"`
+	Reason    string        `json:"reason"`
+	Data      *StorageData  `json:"data,omitempty"`
+	Previous  *StorageData  `json:"previous,omitempty"`
+}
+
+type StoragePlan struct {
+	CreatedAt time.Time  `json:"createdAt"`
+	Steps     []PlanStep `json:"steps"`
+}
+
+type PlanStepResult struct {
+	PlanStep
+	Status storageResponseStatus `json:"status"`
+}
+
+// StoragePlanner compares desired records per device with the contents the Registry last confirmed.
+// Deletions come first on every device so that updates and additions find free flash.
+type StoragePlanner struct {
+	Registry *KeyRegistry
+	Now      func() time.Time
+}
+
+func (p *StoragePlanner) Plan(desired map[string][]StorageData) StoragePlan {
+	plan := StoragePlan{CreatedAt: time.Now()}
+	if p.Now != nil {
+		plan.CreatedAt = p.Now()
+	}
+
+	for extAddr, records := range desired {
+		wanted := make(map[string]bool, len(records))
+
+		for i := range records {
+			data := records[i]
+			data.Status = StorageResponseStatusOk
+			wanted[data.HashKey] = true
+
+			current, ok := p.Registry.Get(extAddr, data.HashKey)
+
+			switch {
+			case !ok:
+				plan.Steps = append(plan.Steps, PlanStep{
+					ExtAddr:   extAddr,
+					Operation: PlanAdd,
+					HashKey:   data.HashKey,
+					Reason:    "not present on device",
+					Data:      &data,
+				})
+			case !current.Equal(&data):
+				plan.Steps = append(plan.Steps, PlanStep{
+					ExtAddr:   extAddr,
+					Operation: PlanUpdate,
+					HashKey:   data.HashKey,
+					Reason:    strings.Join(storageDataChanges(&current, &data), ", "),
+					Data:      &data,
+					Previous:  &current,
+				})
+			}
+		}
+
+		for _, known := range p.Registry.Keys(extAddr) {
+			if !wanted[known.HashKey] {
+				current := known
+				plan.Steps = append(plan.Steps, PlanStep{
+					ExtAddr:   extAddr,
+					Operation: PlanDelete,
+					HashKey:   known.HashKey,
+					Reason:    "not in desired records",
+					Previous:  &current,
+				})
+			}
+		}
+	}
+
+	sort.Slice(plan.Steps, func(i, j int) bool {
+		a, b := &plan.Steps[i], &plan.Steps[j]
+
+		if a.ExtAddr != b.ExtAddr {
+			return a.ExtAddr < b.ExtAddr
+		}
+
+		if a.Operation != b.Operation {
+			return a.Operation.order() < b.Operation.order()
+		}
+
+		return a.HashKey < b.HashKey
+	})
+
+	return plan
+}
+
+// Validate checks that every step can be applied as written, as a plan may have been edited after it was made.
+func (p *StoragePlan) Validate() error {
+	for i, step := range p.Steps {
+		var err error
+
+		switch step.Operation {
+		case PlanAdd, PlanUpdate:
+			if step.Data == nil {
+				err = InvalidPlanStep{"no data to " + string(step.Operation)}
+			} else if step.Data.HashKey != step.HashKey {
+				err = InvalidPlanStep{"data is for hashKey " + step.Data.HashKey}
+			}
+		case PlanDelete:
+		default:
+			err = InvalidPlanOperation{step.Operation}
+		}
+
+		if err != nil {
+			return PlanStepError{Index: i, ExtAddr: step.ExtAddr, HashKey: step.HashKey, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Execute applies the steps in order and stops at the first one that fails. Nothing is applied unless every step
+// is valid, and a step whose device contents drifted from what the plan was reviewed against is not applied.
+func (p *StoragePlan) Execute(ctx context.Context, storage *StorageClient, registry *KeyRegistry) ([]PlanStepResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	results := make([]PlanStepResult, 0, len(p.Steps))
+
+	for i, step := range p.Steps {
+		current, ok := registry.Get(step.ExtAddr, step.HashKey)
+
+		if ok != (step.Previous != nil) || ok && !current.Equal(step.Previous) {
+			return results, PlanStepError{Index: i, ExtAddr: step.ExtAddr, HashKey: step.HashKey, Err: PlanDriftError{step.ExtAddr, step.HashKey}}
+		}
+
+		var rsp *StorageResponse
+		var err error
+
+		switch step.Operation {
+		case PlanAdd:
+			rsp, err = storage.AddKey(ctx, step.ExtAddr, *step.Data)
+		case PlanUpdate:
+			rsp, err = storage.UpdateKey(ctx, step.ExtAddr, *step.Data)
+		case PlanDelete:
+			rsp, err = storage.DeleteKey(ctx, step.ExtAddr, step.HashKey)
+		default:
+			err = InvalidPlanOperation{step.Operation}
+		}
+
+		if err != nil {
+			return results, PlanStepError{Index: i, ExtAddr: step.ExtAddr, HashKey: step.HashKey, Err: err}
+		}
+
+		if step.Operation == PlanDelete {
+			registry.Remove(step.ExtAddr, step.HashKey)
+		} else {
+			registry.Put(step.ExtAddr, *step.Data)
+		}
+
+		results = append(results, PlanStepResult{PlanStep: step, Status: rsp.Status})
+	}
+
+	return results, nil
+}
+
+func storageDataChanges(current, desired *StorageData) []string {
+	c, d := current.normalized(), desired.normalized()
+
+	var changes []string
+
+	if c.Flags != d.Flags {
+		changes = append(changes, "flags changed")
+	}
+
+	if !reflect.DeepEqual(c.MasterKey, d.MasterKey) {
+		changes = append(changes, "master key channels changed")
+	}
+
+	if !reflect.DeepEqual(c.TimeKeys, d.TimeKeys) {
+		changes = append(changes, "time keys changed")
+	}
+
+	if !reflect.DeepEqual(c.AclKeys, d.AclKeys) {
+		changes = append(changes, "acl keys changed")
+	}
+
+	return changes
+}
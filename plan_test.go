@@ -0,0 +1,135 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestStoragePlanRejectsStepWithoutData(t *testing.T) {
+	var plan StoragePlan
+
+	err := json.Unmarshal([]byte(`{"steps":[
+		{"extAddr":"lock","operation":"delete","hashKey":"old","reason":"","previous":{"status":0,"hashKey":"old","flags":{}}},
+		{"extAddr":"lock","operation":"add","hashKey":"new","reason":""}
+	]}`), &plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := &fakeStorage{}
+	device.put("lock", StorageData{HashKey: "old"})
+
+	registry := &KeyRegistry{}
+	registry.Put("lock", StorageData{HashKey: "old"})
+
+	_, err = plan.Execute(context.Background(), &StorageClient{Transport: device}, registry)
+
+	var stepErr PlanStepError
+	if !errors.As(err, &stepErr) || stepErr.Index != 1 {
+		t.Fatalf("err = %v, want an error for step 1", err)
+	}
+
+	if _, ok := device.key("lock", "old"); !ok {
+		t.Fatal("a step was applied from an invalid plan")
+	}
+}
+
+func planFixture() (*KeyRegistry, map[string][]StorageData) {
+	registry := &KeyRegistry{}
+	registry.Put("lock", StorageData{HashKey: "keep", Flags: Flags{MasterKey: true}})
+	registry.Put("lock", StorageData{HashKey: "change", Flags: Flags{MasterKey: true}})
+	registry.Put("lock", StorageData{HashKey: "drop", Flags: Flags{MasterKey: true}})
+
+	desired := map[string][]StorageData{"lock": {
+		{HashKey: "keep", Flags: Flags{MasterKey: true}},
+		{HashKey: "change", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: []int{2}}},
+		{HashKey: "new", Flags: Flags{MasterKey: true}},
+	}}
+
+	return registry, desired
+}
+
+func TestStoragePlannerOrdersSteps(t *testing.T) {
+	registry, desired := planFixture()
+	plan := (&StoragePlanner{Registry: registry}).Plan(desired)
+
+	var got []string
+	for _, step := range plan.Steps {
+		got = append(got, string(step.Operation)+" "+step.HashKey)
+	}
+
+	want := []string{"delete drop", "update change", "add new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("steps %v, want %v", got, want)
+	}
+
+	if plan.Steps[1].Reason != "master key channels changed" || plan.Steps[1].Previous == nil {
+		t.Fatalf("update step %+v", plan.Steps[1])
+	}
+}
+
+func TestStoragePlanRoundTripsAndExecutes(t *testing.T) {
+	registry, desired := planFixture()
+
+	device := &fakeStorage{}
+	for _, data := range registry.Keys("lock") {
+		device.put("lock", data)
+	}
+
+	bytes, err := json.Marshal((&StoragePlanner{Registry: registry}).Plan(desired))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan StoragePlan
+	if err = json.Unmarshal(bytes, &plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = plan.Execute(context.Background(), &StorageClient{Transport: device}, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range desired["lock"] {
+		if onDevice, ok := device.key("lock", data.HashKey); !ok || !onDevice.Equal(&data) {
+			t.Fatalf("device holds %+v for %s", onDevice, data.HashKey)
+		}
+	}
+
+	if _, ok := device.key("lock", "drop"); ok {
+		t.Fatal("deleted key is still on the device")
+	}
+}
+
+func TestStoragePlanStopsOnDrift(t *testing.T) {
+	registry, desired := planFixture()
+
+	device := &fakeStorage{}
+	for _, data := range registry.Keys("lock") {
+		device.put("lock", data)
+	}
+
+	plan := (&StoragePlanner{Registry: registry}).Plan(desired)
+
+	// The key to update changed after the plan was reviewed.
+	registry.Put("lock", StorageData{HashKey: "change", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: []int{3}}})
+
+	results, err := plan.Execute(context.Background(), &StorageClient{Transport: device}, registry)
+
+	var drift PlanDriftError
+	if !errors.As(err, &drift) || drift.HashKey != "change" {
+		t.Fatalf("err = %v, want drift of change", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("applied %d steps before the drifted one, want 1", len(results))
+	}
+
+	if _, ok := device.key("lock", "new"); ok {
+		t.Fatal("a step after the drifted one was applied")
+	}
+}
@@ -0,0 +1,518 @@
+package messages
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const policyDateLayout = "2006-01-02"
+
+// Policy is the StorageData described by a policy text such as
+//
+//	Mon-Fri 08:00-18:00 channels 1,2 until 2027-01-01; master on channel 3
+//
+// Clauses are separated by ";". A weekly schedule is a list of days ("Mon-Fri", "Sat,Sun", "daily") followed by a
+// window, optional channels and an optional "until" date. Devices cannot bound an AclKey, so the date is kept in
+// AclUntil and enforced by pushing Effective at the moments returned by Changes. "from DATE until DATE [channels]"
+// is a TimeKey, "master [on channels]" a master key, and "privacy override", "multi channel" and "meeting mode"
+// set the remaining flags. Dates are device wall clock dates, optionally followed by HH:MM.
+type Policy struct {
+	StorageData
+	AclUntil []time.Time
+}
+
+type policyToken struct {
+	text string
+	pos  int
+}
+
+type policyParser struct {
+	input  string
+	tokens []policyToken
+	i      int
+}
+
+func ParsePolicy(text string) (Policy, error) {
+	var policy Policy
+
+	p := &policyParser{input: text, tokens: tokenizePolicy(text)}
+
+	for !p.done() {
+		if p.accept(";") {
+			continue
+		}
+
+		if err := p.clause(&policy); err != nil {
+			return Policy{}, err
+		}
+
+		if !p.done() && !p.accept(";") {
+			return Policy{}, p.fail("expected \";\" between clauses")
+		}
+	}
+
+	return policy, nil
+}
+
+func tokenizePolicy(text string) []policyToken {
+	var tokens []policyToken
+
+	start := -1
+
+	for i, r := range text {
+		separator := r == ';' || r == ','
+
+		if (separator || r == ' ' || r == '\t' || r == '\n' || r == '\r') && start >= 0 {
+			tokens = append(tokens, policyToken{text[start:i], start})
+			start = -1
+		}
+
+		switch {
+		case separator:
+			tokens = append(tokens, policyToken{string(r), i})
+		case start < 0 && r != ' ' && r != '\t' && r != '\n' && r != '\r':
+			start = i
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, policyToken{text[start:], start})
+	}
+
+	return tokens
+}
+
+func (p *policyParser) done() bool { return p.i >= len(p.tokens) }
+
+func (p *policyParser) peek() string {
+	if p.done() {
+		return ""
+	}
+
+	return strings.ToLower(p.tokens[p.i].text)
+}
+
+func (p *policyParser) accept(word string) bool {
+	if p.peek() == word {
+		p.i++
+		return true
+	}
+
+	return false
+}
+
+func (p *policyParser) fail(reason string) error {
+	if p.done() {
+		return PolicySyntaxError{Offset: len(p.input), Reason: reason}
+	}
+
+	return PolicySyntaxError{Offset: p.tokens[p.i].pos, Token: p.tokens[p.i].text, Reason: reason}
+}
+
+func (p *policyParser) clause(policy *Policy) error {
+	switch p.peek() {
+	case "master":
+		p.i++
+		policy.Flags.MasterKey = true
+
+		if p.accept("on") || p.peek() == "channel" || p.peek() == "channels" {
+			channels, err := p.channels()
+
+			if err != nil {
+				return err
+			}
+
+			policy.MasterKey.ChannelIds = channels
+		}
+
+		return nil
+	case "privacy":
+		p.i++
+		policy.Flags.PrivacyOverride = true
+		return p.expect("override")
+	case "multi":
+		p.i++
+		policy.Flags.IsMultiChannel = true
+		return p.expect("channel")
+	case "meeting":
+		p.i++
+		policy.Flags.IsMeetingModeAllowed = true
+		return p.expect("mode")
+	case "from", "until":
+		return p.timeKey(policy)
+	default:
+		return p.aclKey(policy)
+	}
+}
+
+func (p *policyParser) expect(word string) error {
+	if !p.accept(word) {
+		return p.fail("expected \"" + word + "\"")
+	}
+
+	return nil
+}
+
+func (p *policyParser) channels() ([]int, error) {
+	if !p.accept("channel") && !p.accept("channels") {
+		return nil, p.fail("expected \"channels\"")
+	}
+
+	var channels []int
+
+	for {
+		id, err := strconv.Atoi(p.peek())
+
+		if err != nil || id < 0 {
+			return nil, p.fail("expected a channel id")
+		}
+
+		p.i++
+		channels = append(channels, id)
+
+		if !p.accept(",") {
+			return channels, nil
+		}
+	}
+}
+
+func (p *policyParser) date() (time.Time, error) {
+	day, err := time.Parse(policyDateLayout, p.peek())
+
+	if err != nil {
+		return time.Time{}, p.fail("expected a date as YYYY-MM-DD")
+	}
+
+	p.i++
+
+	if at, err := ParseTimeOfDay(p.peek()); err == nil {
+		p.i++
+		day = at.On(day)
+	}
+
+	return day, nil
+}
+
+func (p *policyParser) timeKey(policy *Policy) error {
+	var k TimeKey
+
+	if p.accept("from") {
+		start, err := p.date()
+
+		if err != nil {
+			return err
+		}
+
+		k.StartTime = int(start.Unix())
+
+		if err = p.expect("until"); err != nil {
+			return err
+		}
+	} else {
+		p.i++
+	}
+
+	pos := p.i
+
+	end, err := p.date()
+
+	if err != nil {
+		return err
+	}
+
+	k.EndTime = int(end.Unix())
+
+	if p.accept("on") || p.peek() == "channel" || p.peek() == "channels" {
+		if k.ChannelIds, err = p.channels(); err != nil {
+			return err
+		}
+	}
+
+	if err = k.Validate(); err != nil {
+		p.i = pos
+		return p.fail("time window ends before it starts")
+	}
+
+	policy.TimeKeys = append(policy.TimeKeys, k)
+
+	return nil
+}
+
+func (p *policyParser) aclKey(policy *Policy) error {
+	var k AclKey
+	var days WeekdaySet
+
+	for {
+		d, err := p.days()
+
+		if err != nil {
+			return err
+		}
+
+		days |= d
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	pos := p.i
+	window := strings.SplitN(p.peek(), "-", 2)
+
+	if len(window) != 2 {
+		return p.fail("expected a time window as HH:MM-HH:MM")
+	}
+
+	start, err := ParseTimeOfDay(window[0])
+
+	if err != nil {
+		return p.fail("invalid start time")
+	}
+
+	end, err := ParseTimeOfDay(window[1])
+
+	if err != nil {
+		return p.fail("invalid end time")
+	}
+
+	k.SetDays(days)
+	k.SetWindow(start, end)
+
+	p.i++
+
+	var until time.Time
+
+	for !p.done() && p.peek() != ";" {
+		switch {
+		case p.accept("on") || p.peek() == "channel" || p.peek() == "channels":
+			if k.ChannelIds, err = p.channels(); err != nil {
+				return err
+			}
+		case p.accept("until"):
+			if until, err = p.date(); err != nil {
+				return err
+			}
+		default:
+			return p.fail("expected \"channels\", \"until\" or \";\"")
+		}
+	}
+
+	if err = k.Validate(); err != nil {
+		p.i = pos
+		return p.fail(err.(InvalidAclKey).Reason)
+	}
+
+	policy.AclKeys = append(policy.AclKeys, k)
+
+	if !until.IsZero() || len(policy.AclUntil) > 0 {
+		for len(policy.AclUntil) < len(policy.AclKeys)-1 {
+			policy.AclUntil = append(policy.AclUntil, time.Time{})
+		}
+
+		policy.AclUntil = append(policy.AclUntil, until)
+	}
+
+	return nil
+}
+
+func (p *policyParser) days() (WeekdaySet, error) {
+	word := p.peek()
+
+	if word == "daily" {
+		p.i++
+		return AllWeekdays, nil
+	}
+
+	bounds := strings.SplitN(word, "-", 2)
+	first, ok := parsePolicyWeekday(bounds[0])
+
+	if !ok {
+		return 0, p.fail("expected a day of week")
+	}
+
+	last := first
+
+	if len(bounds) == 2 {
+		if last, ok = parsePolicyWeekday(bounds[1]); !ok {
+			return 0, p.fail("expected a day of week after \"-\"")
+		}
+	}
+
+	p.i++
+
+	var days WeekdaySet
+
+	for d := first; ; d = (d + 1) % 7 {
+		days = days.With(d)
+
+		if d == last {
+			return days, nil
+		}
+	}
+}
+
+func parsePolicyWeekday(s string) (time.Weekday, bool) {
+	if len(s) < 3 {
+		return 0, false
+	}
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.HasPrefix(strings.ToLower(d.String()), s) {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+// Effective returns the data a device should hold at deviceClock, without the schedules whose until date passed.
+func (p *Policy) Effective(deviceClock time.Time) StorageData {
+	data := p.StorageData
+
+	if len(p.AclUntil) == 0 {
+		return data
+	}
+
+	now := deviceSeconds(deviceClock, deviceClock.Location())
+	data.AclKeys = nil
+
+	for i, k := range p.AclKeys {
+		if i >= len(p.AclUntil) || p.AclUntil[i].IsZero() || now < p.AclUntil[i].Unix() {
+			data.AclKeys = append(data.AclKeys, k)
+		}
+	}
+
+	return data
+}
+
+// Changes returns the device wall clock moments, encoded like TimeKey bounds, at which Effective changes.
+func (p *Policy) Changes() []time.Time {
+	var changes []time.Time
+
+	for _, until := range p.AclUntil {
+		if !until.IsZero() {
+			changes = append(changes, until)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Before(changes[j]) })
+
+	return changes
+}
+
+func (p *Policy) String() string {
+	var clauses []string
+
+	for i, k := range p.AclKeys {
+		start, end, _ := k.Window()
+		clause := renderPolicyDays(k.Days()) + " " + start.String() + "-" + end.String()
+
+		if len(k.ChannelIds) > 0 {
+			clause += " " + renderPolicyChannels(k.ChannelIds)
+		}
+
+		if i < len(p.AclUntil) && !p.AclUntil[i].IsZero() {
+			clause += " until " + renderPolicyDate(p.AclUntil[i].Unix())
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	for _, k := range p.TimeKeys {
+		clause := "until " + renderPolicyDate(int64(k.EndTime))
+
+		if k.StartTime != 0 {
+			clause = "from " + renderPolicyDate(int64(k.StartTime)) + " " + clause
+		}
+
+		if len(k.ChannelIds) > 0 {
+			clause += " " + renderPolicyChannels(k.ChannelIds)
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	if p.Flags.MasterKey {
+		clause := "master"
+
+		if len(p.MasterKey.ChannelIds) > 0 {
+			clause += " on " + renderPolicyChannels(p.MasterKey.ChannelIds)
+		}
+
+		clauses = append(clauses, clause)
+	}
+
+	if p.Flags.PrivacyOverride {
+		clauses = append(clauses, "privacy override")
+	}
+
+	if p.Flags.IsMultiChannel {
+		clauses = append(clauses, "multi channel")
+	}
+
+	if p.Flags.IsMeetingModeAllowed {
+		clauses = append(clauses, "meeting mode")
+	}
+
+	return strings.Join(clauses, "; ")
+}
+
+func renderPolicyDays(days WeekdaySet) string {
+	if days == AllWeekdays {
+		return "daily"
+	}
+
+	// Runs are rendered Monday first, so a range may wrap over Sunday only when it starts on Sunday.
+	order := [...]time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+	var parts []string
+
+	for i := 0; i < len(order); i++ {
+		if !days.Contains(order[i]) {
+			continue
+		}
+
+		j := i
+		for j+1 < len(order) && days.Contains(order[j+1]) {
+			j++
+		}
+
+		switch {
+		case j-i >= 2:
+			parts = append(parts, weekdayNames[order[i]]+"-"+weekdayNames[order[j]])
+		case j > i:
+			parts = append(parts, weekdayNames[order[i]], weekdayNames[order[j]])
+		default:
+			parts = append(parts, weekdayNames[order[i]])
+		}
+
+		i = j
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func renderPolicyChannels(channels []int) string {
+	ids := make([]string, len(channels))
+	for i, id := range channels {
+		ids[i] = strconv.Itoa(id)
+	}
+
+	if len(ids) == 1 {
+		return "channel " + ids[0]
+	}
+
+	return "channels " + strings.Join(ids, ",")
+}
+
+func renderPolicyDate(seconds int64) string {
+	t := time.Unix(seconds, 0).UTC()
+
+	if at := TimeOfDayOf(t); at != 0 {
+		return t.Format(policyDateLayout) + " " + at.String()
+	}
+
+	return t.Format(policyDateLayout)
+}
@@ -0,0 +1,111 @@
+package messages
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicyExample(t *testing.T) {
+	const text = "Mon-Fri 08:00-18:00 channels 1,2 until 2027-01-01; master on channel 3"
+
+	policy, err := ParsePolicy(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policy.AclKeys) != 1 || !policy.Flags.MasterKey || len(policy.MasterKey.ChannelIds) != 1 || policy.MasterKey.ChannelIds[0] != 3 {
+		t.Fatalf("policy %+v", policy)
+	}
+
+	k := policy.AclKeys[0]
+	start, end, err := k.Window()
+
+	if err != nil || start.String() != "08:00" || end.String() != "18:00" {
+		t.Fatalf("window %v-%v, %v", start, end, err)
+	}
+
+	if days := k.Days(); days != AllWeekdays.Without(time.Saturday).Without(time.Sunday) {
+		t.Fatalf("days %v", days)
+	}
+
+	if len(k.ChannelIds) != 2 || len(policy.AclUntil) != 1 || !policy.AclUntil[0].Equal(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("acl key %+v until %v", k, policy.AclUntil)
+	}
+
+	if rendered := policy.String(); rendered != text {
+		t.Fatalf("rendered %q", rendered)
+	}
+
+	reparsed, err := ParsePolicy(policy.String())
+	if err != nil || !reparsed.StorageData.Equal(&policy.StorageData) {
+		t.Fatalf("round trip %+v, %v", reparsed, err)
+	}
+}
+
+func TestParsePolicyClauses(t *testing.T) {
+	policy, err := ParsePolicy("from 2024-05-01 08:00 until 2024-05-03 channels 4; Sat,Sun 22:00-06:00; privacy override; multi channel; meeting mode")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(policy.TimeKeys) != 1 || policy.TimeKeys[0].StartTime != int(time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC).Unix()) {
+		t.Fatalf("time keys %+v", policy.TimeKeys)
+	}
+
+	if len(policy.AclKeys) != 1 || !policy.AclKeys[0].Overnight() {
+		t.Fatalf("acl keys %+v", policy.AclKeys)
+	}
+
+	if !policy.Flags.PrivacyOverride || !policy.Flags.IsMultiChannel || !policy.Flags.IsMeetingModeAllowed || policy.Flags.MasterKey {
+		t.Fatalf("flags %+v", policy.Flags)
+	}
+
+	reparsed, err := ParsePolicy(policy.String())
+	if err != nil || !reparsed.StorageData.Equal(&policy.StorageData) {
+		t.Fatalf("round trip of %q: %+v, %v", policy.String(), reparsed, err)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := []struct {
+		text   string
+		offset int
+		reason string
+	}{
+		{"Mon-Xyz 08:00-18:00", 0, "expected a day of week after \"-\""},
+		{"Mon-Fri 08:00", 8, "expected a time window as HH:MM-HH:MM"},
+		{"Mon-Fri 25:00-18:00", 8, "invalid start time"},
+		{"Mon-Fri 08:00-18:00 channels x", 29, "expected a channel id"},
+		{"Mon-Fri 08:00-18:00 daily", 20, "expected \"channels\", \"until\" or \";\""},
+		{"from 2024-05-03 until 2024-05-01", 22, "time window ends before it starts"},
+		{"privacy", 7, "expected \"override\""},
+		{"master master", 7, "expected \";\" between clauses"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParsePolicy(tt.text)
+
+		syntaxErr, ok := err.(PolicySyntaxError)
+		if !ok || syntaxErr.Offset != tt.offset || syntaxErr.Reason != tt.reason {
+			t.Errorf("%q: %v, want %q at offset %d", tt.text, err, tt.reason, tt.offset)
+		}
+	}
+}
+
+func TestPolicyEffectiveDropsExpiredSchedules(t *testing.T) {
+	policy, err := ParsePolicy("daily 08:00-18:00 until 2027-01-01; Sat 10:00-12:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := policy.Effective(time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC))
+	after := policy.Effective(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	if len(before.AclKeys) != 2 || len(after.AclKeys) != 1 {
+		t.Fatalf("effective before %d, after %d acl keys", len(before.AclKeys), len(after.AclKeys))
+	}
+
+	if changes := policy.Changes(); len(changes) != 1 {
+		t.Fatalf("changes %v", changes)
+	}
+}
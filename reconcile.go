@@ -0,0 +1,170 @@
+package messages
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	KeyAdded     keyChangeAction = "added"
+	KeyUpdated   keyChangeAction = "updated"
+	KeyDeleted   keyChangeAction = "deleted"
+	KeyUnchanged keyChangeAction = "unchanged"
+)
+
+type keyChangeAction string
+
+type KeyChange struct {
+	HashKey string                `json:"hashKey"`
+	Action  keyChangeAction       `json:"action"`
+	Status  storageResponseStatus `json:"status"`
+	Error   string                `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	ExtAddr string      `json:"extAddr"`
+	Changes []KeyChange `json:"changes"`
+}
+
+func (r *ReconcileReport) Changed() []KeyChange {
+	var changed []KeyChange
+
+	for _, c := range r.Changes {
+		if c.Action != KeyUnchanged && c.Error == "" {
+			changed = append(changed, c)
+		}
+	}
+
+	return changed
+}
+
+func (r *ReconcileReport) Failed() []KeyChange {
+	var failed []KeyChange
+
+	for _, c := range r.Changes {
+		if c.Error != "" {
+			failed = append(failed, c)
+		}
+	}
+
+	return failed
+}
+
+// KeyReconciler converges the flash of a device to the desired records. Keys the Registry knows on the device
+// that are not desired any more are deleted; the Registry is kept up to date with every confirmed change.
+type KeyReconciler struct {
+	Storage  *StorageClient
+	Registry *KeyRegistry
+}
+
+func (r *KeyReconciler) Reconcile(ctx context.Context, extAddr string, desired []StorageData) (ReconcileReport, error) {
+	report := ReconcileReport{ExtAddr: extAddr}
+	wanted := make(map[string]bool, len(desired))
+
+	for i := range desired {
+		wanted[desired[i].HashKey] = true
+		report.Changes = append(report.Changes, r.converge(ctx, extAddr, &desired[i]))
+	}
+
+	if r.Registry != nil {
+		for _, known := range r.Registry.Keys(extAddr) {
+			if !wanted[known.HashKey] {
+				report.Changes = append(report.Changes, r.remove(ctx, extAddr, known.HashKey))
+			}
+		}
+	}
+
+	sort.SliceStable(report.Changes, func(i, j int) bool { return report.Changes[i].HashKey < report.Changes[j].HashKey })
+
+	if failed := report.Failed(); len(failed) > 0 {
+		hashKeys := make([]string, len(failed))
+		for i, c := range failed {
+			hashKeys[i] = c.HashKey
+		}
+
+		return report, ReconcileError{ExtAddr: extAddr, HashKeys: hashKeys}
+	}
+
+	return report, nil
+}
+
+func (r *KeyReconciler) converge(ctx context.Context, extAddr string, desired *StorageData) KeyChange {
+	current, err := r.Storage.GetKey(ctx, extAddr, desired.HashKey)
+
+	switch {
+	case err == nil && current.StorageData.Equal(desired):
+		r.remember(extAddr, desired)
+		return KeyChange{HashKey: desired.HashKey, Action: KeyUnchanged, Status: current.Status}
+	case err == nil:
+		return r.update(ctx, extAddr, desired, true)
+	case isStorageStatus(err, StorageResponseStatusErrorKeyNotFound):
+		return r.add(ctx, extAddr, desired, true)
+	default:
+		return keyChangeFailed(desired.HashKey, KeyUnchanged, current, err)
+	}
+}
+
+func (r *KeyReconciler) add(ctx context.Context, extAddr string, desired *StorageData, retry bool) KeyChange {
+	rsp, err := r.Storage.AddKey(ctx, extAddr, *desired)
+
+	if retry && isStorageStatus(err, StorageResponseStatusErrorKeyAlreadyExists) {
+		return r.update(ctx, extAddr, desired, false)
+	}
+
+	if err != nil {
+		return keyChangeFailed(desired.HashKey, KeyAdded, rsp, err)
+	}
+
+	r.remember(extAddr, desired)
+
+	return KeyChange{HashKey: desired.HashKey, Action: KeyAdded, Status: rsp.Status}
+}
+
+func (r *KeyReconciler) update(ctx context.Context, extAddr string, desired *StorageData, retry bool) KeyChange {
+	rsp, err := r.Storage.UpdateKey(ctx, extAddr, *desired)
+
+	if retry && isStorageStatus(err, StorageResponseStatusErrorKeyNotFound) {
+		return r.add(ctx, extAddr, desired, false)
+	}
+
+	if err != nil {
+		return keyChangeFailed(desired.HashKey, KeyUpdated, rsp, err)
+	}
+
+	r.remember(extAddr, desired)
+
+	return KeyChange{HashKey: desired.HashKey, Action: KeyUpdated, Status: rsp.Status}
+}
+
+func (r *KeyReconciler) remove(ctx context.Context, extAddr, hashKey string) KeyChange {
+	rsp, err := r.Storage.DeleteKey(ctx, extAddr, hashKey)
+
+	if isStorageStatus(err, StorageResponseStatusErrorKeyNotFound) {
+		r.Registry.Remove(extAddr, hashKey)
+		return KeyChange{HashKey: hashKey, Action: KeyUnchanged, Status: rsp.Status}
+	}
+
+	if err != nil {
+		return keyChangeFailed(hashKey, KeyDeleted, rsp, err)
+	}
+
+	r.Registry.Remove(extAddr, hashKey)
+
+	return KeyChange{HashKey: hashKey, Action: KeyDeleted, Status: rsp.Status}
+}
+
+func (r *KeyReconciler) remember(extAddr string, data *StorageData) {
+	if r.Registry != nil {
+		r.Registry.Put(extAddr, *data)
+	}
+}
+
+func keyChangeFailed(hashKey string, action keyChangeAction, rsp *StorageResponse, err error) KeyChange {
+	change := KeyChange{HashKey: hashKey, Action: action, Error: err.Error()}
+
+	if rsp != nil {
+		change.Status = rsp.Status
+	}
+
+	return change
+}
@@ -0,0 +1,122 @@
+package messages
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestKeyReconciler(t *testing.T) {
+	master := StorageData{HashKey: "master", Flags: Flags{MasterKey: true}}
+	changed := StorageData{HashKey: "master", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: []int{1}}}
+	other := StorageData{HashKey: "other", Flags: Flags{MasterKey: true}}
+
+	tests := []struct {
+		name     string
+		device   []StorageData
+		known    []StorageData
+		desired  []StorageData
+		changes  []KeyChange
+		onDevice []string
+	}{
+		{
+			name:     "missing key is added",
+			desired:  []StorageData{master},
+			changes:  []KeyChange{{HashKey: "master", Action: KeyAdded, Status: StorageResponseStatusOk}},
+			onDevice: []string{"master"},
+		},
+		{
+			name:     "equal key is unchanged",
+			device:   []StorageData{master},
+			desired:  []StorageData{master},
+			changes:  []KeyChange{{HashKey: "master", Action: KeyUnchanged, Status: StorageResponseStatusReadOk}},
+			onDevice: []string{"master"},
+		},
+		{
+			name:     "mismatched key is updated",
+			device:   []StorageData{master},
+			desired:  []StorageData{changed},
+			changes:  []KeyChange{{HashKey: "master", Action: KeyUpdated, Status: StorageResponseStatusOk}},
+			onDevice: []string{"master"},
+		},
+		{
+			name:    "extra known key is deleted",
+			device:  []StorageData{master, other},
+			known:   []StorageData{master, other},
+			desired: []StorageData{master},
+			changes: []KeyChange{
+				{HashKey: "master", Action: KeyUnchanged, Status: StorageResponseStatusReadOk},
+				{HashKey: "other", Action: KeyDeleted, Status: StorageResponseStatusOk},
+			},
+			onDevice: []string{"master"},
+		},
+		{
+			name:     "extra key already gone is unchanged",
+			known:    []StorageData{other},
+			changes:  []KeyChange{{HashKey: "other", Action: KeyUnchanged, Status: StorageResponseStatusErrorKeyNotFound}},
+			onDevice: []string{},
+		},
+		{
+			name:     "unknown extra key is left alone",
+			device:   []StorageData{other},
+			onDevice: []string{"other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &fakeStorage{}
+			for _, data := range tt.device {
+				device.put("lock", data)
+			}
+
+			registry := &KeyRegistry{}
+			for _, data := range tt.known {
+				registry.Put("lock", data)
+			}
+
+			reconciler := &KeyReconciler{Storage: &StorageClient{Transport: device}, Registry: registry}
+
+			report, err := reconciler.Reconcile(context.Background(), "lock", tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(report.Changes, tt.changes) {
+				t.Fatalf("changes %+v, want %+v", report.Changes, tt.changes)
+			}
+
+			onDevice := []string{}
+			for _, hashKey := range []string{"master", "other"} {
+				if _, ok := device.key("lock", hashKey); ok {
+					onDevice = append(onDevice, hashKey)
+				}
+			}
+
+			if !reflect.DeepEqual(onDevice, tt.onDevice) {
+				t.Fatalf("device holds %v, want %v", onDevice, tt.onDevice)
+			}
+
+			for _, data := range tt.desired {
+				if known, ok := registry.Get("lock", data.HashKey); !ok || !known.Equal(&data) {
+					t.Fatalf("registry holds %+v for %s", known, data.HashKey)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyReconcilerReportsFailedKeys(t *testing.T) {
+	device := &fakeStorage{refuse: map[string]storageResponseStatus{"lock": StorageResponseStatusErrorCritical}}
+	reconciler := &KeyReconciler{Storage: &StorageClient{Transport: device}, Registry: &KeyRegistry{}}
+
+	report, err := reconciler.Reconcile(context.Background(), "lock", []StorageData{{HashKey: "master", Flags: Flags{MasterKey: true}}})
+
+	if !reflect.DeepEqual(err, ReconcileError{ExtAddr: "lock", HashKeys: []string{"master"}}) {
+		t.Fatalf("error %v", err)
+	}
+
+	if len(report.Failed()) != 1 || len(report.Changed()) != 0 {
+		t.Fatalf("report %+v", report)
+	}
+}
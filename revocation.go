@@ -0,0 +1,330 @@
+package messages
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	RevocationDone   revocationState = "done"
+	RevocationQueued revocationState = "queued"
+	RevocationFailed revocationState = "failed"
+)
+
+type revocationState string
+
+type RevocationResult struct {
+	ExtAddr string                `json:"extAddr"`
+	State   revocationState       `json:"state"`
+	Status  storageResponseStatus `json:"status"`
+	Error   string                `json:"error,omitempty"`
+	At      time.Time             `json:"at"`
+}
+
+type RevocationReport struct {
+	HashKey     string             `json:"hashKey"`
+	RequestedAt time.Time          `json:"requestedAt"`
+	Devices     []RevocationResult `json:"devices"`
+}
+
+func (r *RevocationReport) Complete() bool {
+	for _, d := range r.Devices {
+		if d.State != RevocationDone {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RevocationStore keeps the revocation reports, which also record the deletions still to deliver.
+type RevocationStore interface {
+	Load() ([]RevocationReport, error)
+	Save(report RevocationReport) error
+}
+
+// FileRevocationStore keeps all reports in one JSON file at Path, rewritten on every change.
+type FileRevocationStore struct {
+	Path string
+
+	records recordFile
+}
+
+func (s *FileRevocationStore) Load() ([]RevocationReport, error) {
+	var reports []RevocationReport
+
+	err := s.records.load(s.Path, func(record []byte) error {
+		var report RevocationReport
+
+		if err := json.Unmarshal(record, &report); err != nil {
+			return err
+		}
+
+		reports = append(reports, report)
+
+		return nil
+	})
+
+	return reports, err
+}
+
+func (s *FileRevocationStore) Save(report RevocationReport) error {
+	return s.records.put(s.Path, report.HashKey, report)
+}
+
+// Revoker deletes a hashKey from every device in Directory. Each report is saved to Store before any deletion is
+// sent and after every result, so it proves the revocation afterwards and survives a restart. Deletions for
+// devices that are offline or unreachable stay queued, and deletions a device refused stay failed; Flush retries
+// both once the device is back.
+type Revoker struct {
+	Storage   *StorageClient
+	Directory DeviceDirectory
+	Store     RevocationStore
+	Now       func() time.Time
+
+	mu      sync.Mutex
+	reports map[string]*RevocationReport
+}
+
+func (r *Revoker) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+
+	return time.Now()
+}
+
+// load reads the reports from Store on first use. It must be called with mu held.
+func (r *Revoker) load() error {
+	if r.reports != nil {
+		return nil
+	}
+
+	if r.Store == nil {
+		return StoreMissing{"revoker"}
+	}
+
+	reports, err := r.Store.Load()
+
+	if err != nil {
+		return err
+	}
+
+	r.reports = make(map[string]*RevocationReport, len(reports))
+
+	for i := range reports {
+		r.reports[reports[i].HashKey] = &reports[i]
+	}
+
+	return nil
+}
+
+// Revoke queues the deletion of hashKey on every device in Directory and sends it to those online. Revoking a
+// hashKey again merges into its report: confirmed deletions stay recorded, and devices that left the Directory keep
+// their pending deletions.
+func (r *Revoker) Revoke(ctx context.Context, hashKey string) (RevocationReport, error) {
+	now := r.now()
+
+	r.mu.Lock()
+	err := r.load()
+
+	report := &RevocationReport{HashKey: hashKey, RequestedAt: now}
+	known := make(map[string]bool)
+
+	if existing, ok := r.reports[hashKey]; ok {
+		report.RequestedAt = existing.RequestedAt
+		report.Devices = append(report.Devices, existing.Devices...)
+
+		for _, d := range existing.Devices {
+			known[d.ExtAddr] = true
+		}
+	}
+
+	for _, extAddr := range r.Directory.Devices() {
+		if !known[extAddr] {
+			report.Devices = append(report.Devices, RevocationResult{ExtAddr: extAddr, State: RevocationQueued, At: now})
+		}
+	}
+
+	sort.Slice(report.Devices, func(i, j int) bool { return report.Devices[i].ExtAddr < report.Devices[j].ExtAddr })
+
+	if err == nil {
+		err = r.Store.Save(*report)
+	}
+	if err == nil {
+		r.reports[hashKey] = report
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		return RevocationReport{}, err
+	}
+
+	var pending []string
+	for _, d := range report.Devices {
+		if d.State != RevocationDone {
+			pending = append(pending, d.ExtAddr)
+		}
+	}
+
+	var errs []error
+
+	for _, extAddr := range pending {
+		if !r.Directory.Online(extAddr) {
+			continue
+		}
+
+		if err = r.record(hashKey, r.delete(ctx, extAddr, hashKey)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	copied, _ := r.Report(hashKey)
+
+	if len(errs) > 0 {
+		return copied, errs[0]
+	}
+
+	var failed []string
+	for _, d := range copied.Devices {
+		if d.State == RevocationFailed {
+			failed = append(failed, d.ExtAddr)
+		}
+	}
+
+	if len(failed) > 0 {
+		return copied, RevocationError{HashKey: hashKey, Devices: failed}
+	}
+
+	return copied, nil
+}
+
+// Flush retries the queued and failed deletions of a device that has come back online.
+func (r *Revoker) Flush(ctx context.Context, extAddr string) error {
+	hashKeys, err := r.Pending(extAddr)
+
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, hashKey := range hashKeys {
+		result := r.delete(ctx, extAddr, hashKey)
+
+		if err = r.record(hashKey, result); err != nil {
+			return err
+		}
+
+		if result.State != RevocationDone {
+			failed = append(failed, hashKey)
+		}
+	}
+
+	if len(failed) > 0 {
+		return FlushError{ExtAddr: extAddr, HashKeys: failed}
+	}
+
+	return nil
+}
+
+func (r *Revoker) Report(hashKey string) (RevocationReport, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.load() != nil {
+		return RevocationReport{}, false
+	}
+
+	report, ok := r.reports[hashKey]
+	if !ok {
+		return RevocationReport{}, false
+	}
+
+	copied := *report
+	copied.Devices = append([]RevocationResult(nil), report.Devices...)
+
+	return copied, true
+}
+
+// Pending returns the hashKeys whose deletion from the device is queued or failed.
+func (r *Revoker) Pending(extAddr string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	var hashKeys []string
+
+	for hashKey, report := range r.reports {
+		for _, d := range report.Devices {
+			if d.ExtAddr == extAddr && d.State != RevocationDone {
+				hashKeys = append(hashKeys, hashKey)
+			}
+		}
+	}
+
+	sort.Strings(hashKeys)
+
+	return hashKeys, nil
+}
+
+// record stores the result of a deletion in the report of hashKey.
+func (r *Revoker) record(hashKey string, result RevocationResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[hashKey]
+	if !ok {
+		return nil
+	}
+
+	updated := *report
+	updated.Devices = append([]RevocationResult(nil), report.Devices...)
+
+	for i := range updated.Devices {
+		if updated.Devices[i].ExtAddr == result.ExtAddr {
+			updated.Devices[i] = result
+		}
+	}
+
+	if err := r.Store.Save(updated); err != nil {
+		return err
+	}
+
+	*report = updated
+
+	return nil
+}
+
+func (r *Revoker) delete(ctx context.Context, extAddr, hashKey string) RevocationResult {
+	result := RevocationResult{ExtAddr: extAddr}
+
+	rsp, err := r.Storage.DeleteKey(ctx, extAddr, hashKey)
+	result.At = r.now()
+
+	switch {
+	case err == nil || isStorageStatus(err, StorageResponseStatusErrorKeyNotFound):
+		result.State = RevocationDone
+	case rsp == nil:
+		result.State = RevocationQueued
+	default:
+		result.State = RevocationFailed
+	}
+
+	if rsp != nil {
+		result.Status = rsp.Status
+	}
+
+	if err != nil && result.State != RevocationDone {
+		result.Error = err.Error()
+	}
+
+	return result
+}
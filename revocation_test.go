@@ -0,0 +1,157 @@
+package messages
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+type fakeDirectory map[string]bool
+
+func (d fakeDirectory) Devices() []string {
+	var devices []string
+	for extAddr := range d {
+		devices = append(devices, extAddr)
+	}
+
+	return devices
+}
+
+func (d fakeDirectory) Online(extAddr string) bool { return d[extAddr] }
+
+func TestRevokerResumesAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "revocations.json")
+
+	device := &fakeStorage{refuse: map[string]storageResponseStatus{"broken": StorageResponseStatusErrorCritical}}
+	for _, extAddr := range []string{"online", "offline", "broken"} {
+		device.put(extAddr, StorageData{HashKey: "key"})
+	}
+
+	directory := fakeDirectory{"online": true, "offline": false, "broken": true}
+
+	revoker := &Revoker{Storage: &StorageClient{Transport: device}, Directory: directory, Store: &FileRevocationStore{Path: path}}
+
+	if _, err := revoker.Revoke(ctx, "key"); err == nil {
+		t.Fatal("revocation on a failing device reported no error")
+	}
+
+	restarted := &Revoker{Storage: &StorageClient{Transport: device}, Directory: directory, Store: &FileRevocationStore{Path: path}}
+
+	for _, extAddr := range []string{"offline", "broken"} {
+		if pending, err := restarted.Pending(extAddr); err != nil || len(pending) != 1 {
+			t.Fatalf("pending on %s = %v, %v", extAddr, pending, err)
+		}
+	}
+
+	delete(device.refuse, "broken")
+
+	for _, extAddr := range []string{"offline", "broken"} {
+		if err := restarted.Flush(ctx, extAddr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, ok := restarted.Report("key")
+
+	if !ok || !report.Complete() {
+		t.Fatalf("report = %+v", report)
+	}
+
+	for _, extAddr := range []string{"online", "offline", "broken"} {
+		if _, ok := device.key(extAddr, "key"); ok {
+			t.Fatalf("key is still on %s", extAddr)
+		}
+	}
+}
+
+func TestRevokerMergesRepeatedRevocation(t *testing.T) {
+	ctx := context.Background()
+
+	device := &fakeStorage{}
+	for _, extAddr := range []string{"a", "b"} {
+		device.put(extAddr, StorageData{HashKey: "key"})
+	}
+
+	directory := fakeDirectory{"a": true, "b": false}
+	revoker := &Revoker{Storage: &StorageClient{Transport: device}, Directory: directory, Store: &FileRevocationStore{Path: filepath.Join(t.TempDir(), "revocations.json")}}
+
+	first, err := revoker.Revoke(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b left the network and c joined before the hashKey is revoked again.
+	delete(directory, "b")
+	directory["c"] = true
+	device.put("c", StorageData{HashKey: "key"})
+
+	if _, err = revoker.Revoke(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, _ := revoker.Report("key")
+
+	if !report.RequestedAt.Equal(first.RequestedAt) || len(report.Devices) != 3 {
+		t.Fatalf("report %+v", report)
+	}
+
+	states := make(map[string]revocationState)
+	for _, d := range report.Devices {
+		states[d.ExtAddr] = d.State
+	}
+
+	if states["a"] != RevocationDone || states["b"] != RevocationQueued || states["c"] != RevocationDone {
+		t.Fatalf("states %v", states)
+	}
+
+	if pending, _ := revoker.Pending("b"); len(pending) != 1 {
+		t.Fatal("the queued deletion of a device that left the directory was dropped")
+	}
+}
+
+func TestRevokerQueuesOfflineDevices(t *testing.T) {
+	ctx := context.Background()
+
+	device := &fakeStorage{}
+	for _, extAddr := range []string{"online", "offline"} {
+		device.put(extAddr, StorageData{HashKey: "key"})
+	}
+
+	revoker := &Revoker{
+		Storage:   &StorageClient{Transport: device},
+		Directory: fakeDirectory{"online": true, "offline": false},
+		Store:     &FileRevocationStore{Path: filepath.Join(t.TempDir(), "revocations.json")},
+	}
+
+	report, err := revoker.Revoke(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Complete() {
+		t.Fatal("revocation complete while a device is offline")
+	}
+
+	if _, ok := device.key("online", "key"); ok {
+		t.Fatal("key is still on the online device")
+	}
+
+	pending, err := revoker.Pending("offline")
+
+	if err != nil || len(pending) != 1 || pending[0] != "key" {
+		t.Fatalf("pending = %v, %v", pending, err)
+	}
+
+	if err = revoker.Flush(ctx, "offline"); err != nil {
+		t.Fatal(err)
+	}
+
+	if report, _ = revoker.Report("key"); !report.Complete() {
+		t.Fatalf("report = %+v", report)
+	}
+
+	if _, ok := device.key("offline", "key"); ok {
+		t.Fatal("key is still on the offline device")
+	}
+}
@@ -0,0 +1,305 @@
+package messages
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const EndOfDay TimeOfDay = 24 * 60 * 60
+
+// TimeOfDay is a wall clock time in seconds since midnight. EndOfDay is only meaningful as the end of a window.
+type TimeOfDay uint32
+
+func NewTimeOfDay(hour, minute, second int) (TimeOfDay, error) {
+	t := TimeOfDay(hour*3600 + minute*60 + second)
+
+	if hour < 0 || minute < 0 || minute > 59 || second < 0 || second > 59 || t > EndOfDay {
+		return 0, InvalidTimeOfDay{fmt.Sprintf("%02d:%02d:%02d", hour, minute, second)}
+	}
+
+	return t, nil
+}
+
+// ParseTimeOfDay accepts "HH:MM" and "HH:MM:SS" on a 24 hour clock, including "24:00" as the end of the day. The
+// hour may have a single digit.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	parts := strings.Split(s, ":")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, InvalidTimeOfDay{s}
+	}
+
+	var values [3]int
+
+	for i, part := range parts {
+		if len(part) != 2 && (i > 0 || len(part) != 1) {
+			return 0, InvalidTimeOfDay{s}
+		}
+
+		for _, c := range []byte(part) {
+			if c < '0' || c > '9' {
+				return 0, InvalidTimeOfDay{s}
+			}
+
+			values[i] = values[i]*10 + int(c-'0')
+		}
+	}
+
+	t, err := NewTimeOfDay(values[0], values[1], values[2])
+
+	if err != nil {
+		return 0, InvalidTimeOfDay{s}
+	}
+
+	return t, nil
+}
+
+func TimeOfDayOf(t time.Time) TimeOfDay {
+	return TimeOfDay(t.Hour()*3600 + t.Minute()*60 + t.Second())
+}
+
+func (t TimeOfDay) Hour() int   { return int(t / 3600) }
+func (t TimeOfDay) Minute() int { return int(t % 3600 / 60) }
+func (t TimeOfDay) Second() int { return int(t % 60) }
+
+func (t TimeOfDay) Duration() time.Duration { return time.Duration(t) * time.Second }
+
+// On returns the moment of day in the location of day.
+func (t TimeOfDay) On(day time.Time) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 0, 0, int(t), 0, day.Location())
+}
+
+func (t TimeOfDay) String() string {
+	if t.Second() != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+	}
+
+	return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+}
+
+func (t *TimeOfDay) UnmarshalJSON(bytes []byte) error {
+	var s string
+
+	if err := json.Unmarshal(bytes, &s); err != nil {
+		return err
+	}
+
+	parsed, err := ParseTimeOfDay(s)
+
+	if err != nil {
+		return err
+	}
+
+	*t = parsed
+
+	return nil
+}
+
+func (t TimeOfDay) MarshalJSON() ([]byte, error) {
+	if t > EndOfDay {
+		return nil, InvalidTimeOfDay{fmt.Sprintf("%ds", uint32(t))}
+	}
+
+	return json.Marshal(t.String())
+}
+
+var weekdayNames = [...]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+const AllWeekdays WeekdaySet = 1<<7 - 1
+
+// WeekdaySet is a set of time.Weekday values. It is encoded as the list of weekday numbers, Sunday being 0.
+type WeekdaySet uint8
+
+func NewWeekdaySet(days ...time.Weekday) WeekdaySet {
+	var s WeekdaySet
+
+	for _, d := range days {
+		s = s.With(d)
+	}
+
+	return s
+}
+
+func (s WeekdaySet) With(d time.Weekday) WeekdaySet {
+	if d < time.Sunday || d > time.Saturday {
+		return s
+	}
+
+	return s | 1<<uint(d)
+}
+
+func (s WeekdaySet) Without(d time.Weekday) WeekdaySet {
+	if d < time.Sunday || d > time.Saturday {
+		return s
+	}
+
+	return s &^ (1 << uint(d))
+}
+
+func (s WeekdaySet) Contains(d time.Weekday) bool {
+	return d >= time.Sunday && d <= time.Saturday && s&(1<<uint(d)) != 0
+}
+
+func (s WeekdaySet) Days() []time.Weekday {
+	days := make([]time.Weekday, 0, 7)
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if s.Contains(d) {
+			days = append(days, d)
+		}
+	}
+
+	return days
+}
+
+func (s WeekdaySet) Len() int { return len(s.Days()) }
+
+func (s WeekdaySet) String() string {
+	names := make([]string, 0, 7)
+
+	for _, d := range s.Days() {
+		names = append(names, weekdayNames[d])
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (s *WeekdaySet) UnmarshalJSON(bytes []byte) error {
+	var days []time.Weekday
+
+	if err := json.Unmarshal(bytes, &days); err != nil {
+		return err
+	}
+
+	*s = 0
+
+	for _, d := range days {
+		if d < time.Sunday || d > time.Saturday {
+			return InvalidWeekday{d}
+		}
+
+		*s = s.With(d)
+	}
+
+	return nil
+}
+
+func (s WeekdaySet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Days())
+}
+
+// NewAclKey returns a key in the text form of the device protocol.
+func NewAclKey(days WeekdaySet, start, end TimeOfDay, channelIds []int) AclKey {
+	k := AclKey{ChannelIds: channelIds}
+	k.SetDays(days)
+	k.SetWindow(start, end)
+
+	return k
+}
+
+// Days returns DaysOfWeek as a set. Days out of range are left out; Validate reports them.
+func (k *AclKey) Days() WeekdaySet {
+	return NewWeekdaySet(k.DaysOfWeek...)
+}
+
+func (k *AclKey) SetDays(days WeekdaySet) {
+	k.DaysOfWeek = days.Days()
+}
+
+// Window parses StartTime and EndTime. The fields keep the text the device sent, so a record read from a device
+// is written back unchanged.
+func (k *AclKey) Window() (start, end TimeOfDay, err error) {
+	if start, err = ParseTimeOfDay(k.StartTime); err != nil {
+		return 0, 0, err
+	}
+
+	if end, err = ParseTimeOfDay(k.EndTime); err != nil {
+		return 0, 0, err
+	}
+
+	return start, end, nil
+}
+
+func (k *AclKey) SetWindow(start, end TimeOfDay) {
+	k.StartTime, k.EndTime = start.String(), end.String()
+}
+
+// canonicalize rewrites a valid key in the form NewAclKey produces, so keys that only differ in notation compare
+// equal. Invalid keys are left as they are.
+func (k *AclKey) canonicalize() {
+	if len(k.DaysOfWeek) == 0 {
+		k.DaysOfWeek = nil
+	}
+
+	if k.Validate() != nil {
+		return
+	}
+
+	start, end, _ := k.Window()
+
+	k.SetDays(k.Days())
+	k.SetWindow(start, end)
+}
+
+// Overnight reports whether the window crosses midnight. DaysOfWeek then names the days the window starts on.
+func (k *AclKey) Overnight() bool {
+	start, end, err := k.Window()
+	return err == nil && end < start
+}
+
+func (k *AclKey) Validate() error {
+	if len(k.DaysOfWeek) == 0 {
+		return InvalidAclKey{*k, "no days of week"}
+	}
+
+	for _, d := range k.DaysOfWeek {
+		if d < time.Sunday || d > time.Saturday {
+			return InvalidAclKey{*k, "unknown day of week"}
+		}
+	}
+
+	start, err := ParseTimeOfDay(k.StartTime)
+
+	if err != nil {
+		return InvalidAclKey{*k, "invalid start time"}
+	}
+
+	end, err := ParseTimeOfDay(k.EndTime)
+
+	switch {
+	case err != nil:
+		return InvalidAclKey{*k, "invalid end time"}
+	case start >= EndOfDay:
+		return InvalidAclKey{*k, "start time out of range"}
+	case start == end:
+		return InvalidAclKey{*k, "empty time window"}
+	default:
+		return nil
+	}
+}
+
+// Contains reports whether the wall clock of t falls into the window. t must be in the time zone of the device.
+// A key whose window does not parse contains no time.
+func (k *AclKey) Contains(t time.Time) bool {
+	start, end, err := k.Window()
+
+	if err != nil {
+		return false
+	}
+
+	at, days := TimeOfDayOf(t), k.Days()
+
+	if end >= start {
+		return days.Contains(t.Weekday()) && at >= start && at < end
+	}
+
+	if at >= start {
+		return days.Contains(t.Weekday())
+	}
+
+	return at < end && days.Contains((t.Weekday()+6)%7)
+}
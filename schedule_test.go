@@ -0,0 +1,89 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestAclKeyKeepsDeviceText(t *testing.T) {
+	for _, window := range [][2]string{{"08:00:00", "17:30:00"}, {"8:00", "17:30"}, {"", ""}} {
+		payload := `{"status":1,"hashKey":"k","flags":{"masterKey":false,"privacyOverride":false,"isMultiChannel":false,"isMeetingModeAllowed":false},` +
+			`"masterKey":{},"aclKeys":[{"daysOfWeek":[1,2],"startTime":"` + window[0] + `","endTime":"` + window[1] + `"}]}`
+
+		var rsp StorageResponse
+
+		err := json.Unmarshal([]byte(`{"eventType":"localStorageResponse","short_addr":"0001","ext_addr":"lock","rssi":-40,`+
+			`"transactionId":1,"payload":`+payload+`}`), &rsp)
+		if err != nil {
+			t.Fatalf("%v: %v", window, err)
+		}
+
+		bytes, err := json.Marshal(&rsp.StorageData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := `"startTime":"` + window[0] + `","endTime":"` + window[1] + `"`; !strings.Contains(string(bytes), want) {
+			t.Fatalf("%s does not contain %s", bytes, want)
+		}
+	}
+}
+
+func TestAclKeyNotationDoesNotMatter(t *testing.T) {
+	a := StorageData{HashKey: "k", AclKeys: []AclKey{{DaysOfWeek: []time.Weekday{2, 1}, StartTime: "8:00", EndTime: "17:30:00"}}}
+	b := StorageData{HashKey: "k", AclKeys: []AclKey{NewAclKey(NewWeekdaySet(1, 2), 8*3600, 17*3600+1800, nil)}}
+
+	if !a.Equal(&b) {
+		t.Fatalf("%+v and %+v differ", a.AclKeys, b.AclKeys)
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"08:30", "08:30", true},
+		{"23:59:30", "23:59:30", true},
+		{"24:00", "24:00", true},
+		{"24:01", "", false},
+		{"12:60", "", false},
+		{"12", "", false},
+		{"1a:00", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimeOfDay(tt.in)
+
+		if (err == nil) != tt.ok || (tt.ok && got.String() != tt.want) {
+			t.Errorf("ParseTimeOfDay(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestAclKeyContainsOvernightWindow(t *testing.T) {
+	start, _ := NewTimeOfDay(22, 0, 0)
+	end, _ := NewTimeOfDay(6, 0, 0)
+	k := NewAclKey(NewWeekdaySet(time.Friday), start, end, []int{1})
+
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC), true},  // Friday night
+		{time.Date(2024, 3, 2, 5, 59, 0, 0, time.UTC), true},  // early Saturday
+		{time.Date(2024, 3, 2, 6, 0, 0, 0, time.UTC), false},  // Saturday morning
+		{time.Date(2024, 3, 2, 23, 0, 0, 0, time.UTC), false}, // Saturday night
+		{time.Date(2024, 3, 1, 5, 0, 0, 0, time.UTC), false},  // early Friday
+	}
+
+	for _, tt := range tests {
+		if got := k.Contains(tt.at); got != tt.want {
+			t.Errorf("Contains(%v) = %v", tt.at, got)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package messages
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const KeySnapshotVersion = 1
+
+type KeySnapshot struct {
+	Version   int           `json:"version"`
+	ExtAddr   string        `json:"extAddr"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Records   []StorageData `json:"records"`
+}
+
+func TakeKeySnapshot(registry *KeyRegistry, extAddr string) KeySnapshot {
+	return KeySnapshot{
+		Version:   KeySnapshotVersion,
+		ExtAddr:   extAddr,
+		CreatedAt: time.Now(),
+		Records:   registry.Keys(extAddr),
+	}
+}
+
+func ReadKeySnapshot(path string) (KeySnapshot, error) {
+	var s KeySnapshot
+
+	bytes, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return s, err
+	}
+
+	if err = json.Unmarshal(bytes, &s); err != nil {
+		return s, err
+	}
+
+	if s.Version != KeySnapshotVersion {
+		return s, UnsupportedSnapshotVersion{s.Version}
+	}
+
+	return s, nil
+}
+
+func (s *KeySnapshot) WriteFile(path string) error {
+	bytes, err := json.MarshalIndent(s, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, bytes, 0600)
+}
+
+type SnapshotDifference struct {
+	HashKey  string       `json:"hashKey"`
+	Reason   string       `json:"reason"`
+	Expected *StorageData `json:"expected,omitempty"`
+	Actual   *StorageData `json:"actual,omitempty"`
+}
+
+type RestoreReport struct {
+	Source      string               `json:"source"`
+	Target      string               `json:"target"`
+	Restored    []string             `json:"restored"`
+	Differences []SnapshotDifference `json:"differences"`
+}
+
+// Restore adds every record of the snapshot to the target device and reads each of them back. Records that fail
+// to add or read back differently are reported as differences; verified records are stored in registry.
+func (s *KeySnapshot) Restore(ctx context.Context, storage *StorageClient, registry *KeyRegistry, extAddr string) (RestoreReport, error) {
+	report := RestoreReport{Source: s.ExtAddr, Target: extAddr}
+
+	for i := range s.Records {
+		expected := s.Records[i]
+		expected.Status = StorageResponseStatusOk
+
+		_, err := storage.AddKey(ctx, extAddr, expected)
+
+		if err != nil && !isStorageStatus(err, StorageResponseStatusErrorKeyAlreadyExists) {
+			report.Differences = append(report.Differences, SnapshotDifference{
+				HashKey:  expected.HashKey,
+				Reason:   "add failed: " + err.Error(),
+				Expected: &expected,
+			})
+
+			continue
+		}
+
+		rsp, err := storage.GetKey(ctx, extAddr, expected.HashKey)
+
+		if err != nil {
+			report.Differences = append(report.Differences, SnapshotDifference{
+				HashKey:  expected.HashKey,
+				Reason:   "verification failed: " + err.Error(),
+				Expected: &expected,
+			})
+
+			continue
+		}
+
+		actual := rsp.StorageData
+		actual.Status = StorageResponseStatusOk
+
+		if !actual.Equal(&expected) {
+			report.Differences = append(report.Differences, SnapshotDifference{
+				HashKey:  expected.HashKey,
+				Reason:   strings.Join(storageDataChanges(&actual, &expected), ", "),
+				Expected: &expected,
+				Actual:   &actual,
+			})
+
+			registry.Put(extAddr, actual)
+
+			continue
+		}
+
+		registry.Put(extAddr, actual)
+		report.Restored = append(report.Restored, expected.HashKey)
+	}
+
+	if len(report.Differences) > 0 {
+		hashKeys := make([]string, len(report.Differences))
+		for i, d := range report.Differences {
+			hashKeys[i] = d.HashKey
+		}
+
+		return report, RestoreError{ExtAddr: extAddr, HashKeys: hashKeys}
+	}
+
+	return report, nil
+}
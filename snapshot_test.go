@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeySnapshotRestoresToReplacementDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	records := []StorageData{
+		{HashKey: "a", Flags: Flags{MasterKey: true}, MasterKey: MasterKey{ChannelIds: []int{1}}},
+		{HashKey: "b", TimeKeys: []TimeKey{{StartTime: 10, EndTime: 20}}},
+	}
+
+	registry := &KeyRegistry{}
+	for _, data := range records {
+		registry.Put("old", data)
+	}
+
+	snapshot := TakeKeySnapshot(registry, "old")
+
+	if err := snapshot.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadKeySnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device := &fakeStorage{}
+
+	report, err := read.Restore(context.Background(), &StorageClient{Transport: device}, registry, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Source != "old" || report.Target != "new" || len(report.Restored) != 2 {
+		t.Fatalf("report %+v", report)
+	}
+
+	for _, data := range records {
+		if onDevice, ok := device.key("new", data.HashKey); !ok || !onDevice.Equal(&data) {
+			t.Fatalf("new device holds %+v for %s", onDevice, data.HashKey)
+		}
+
+		if _, ok := registry.Get("new", data.HashKey); !ok {
+			t.Fatalf("registry does not know %s on the new device", data.HashKey)
+		}
+	}
+}
+
+func TestKeySnapshotReportsRefusedRecords(t *testing.T) {
+	snapshot := KeySnapshot{Version: KeySnapshotVersion, ExtAddr: "old", Records: []StorageData{{HashKey: "a", Flags: Flags{MasterKey: true}}}}
+	device := &fakeStorage{refuse: map[string]storageResponseStatus{"new": StorageResponseStatusErrorFlashStorageFull}}
+
+	report, err := snapshot.Restore(context.Background(), &StorageClient{Transport: device}, &KeyRegistry{}, "new")
+	if err == nil || len(report.Differences) != 1 || report.Differences[0].HashKey != "a" {
+		t.Fatalf("report %+v, %v", report, err)
+	}
+}
+
+func TestReadKeySnapshotRejectsUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"version":2,"records":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadKeySnapshot(path); err != (UnsupportedSnapshotVersion{2}) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package messages
 
 import (
 	"github.com/goccy/go-json"
+	"reflect"
 	"time"
 )
 
@@ -44,6 +45,10 @@ func (s *storageResponseStatus) MarshalJSON() ([]byte, error) {
 	return json.Marshal((*uint8)(s))
 }
 
+func (s storageResponseStatus) Ok() bool {
+	return s == StorageResponseStatusOk || s == StorageResponseStatusReadOk
+}
+
 type MasterKey struct {
 	ChannelIds []int `json:"channelIds,omitempty"`
 }
@@ -77,6 +82,51 @@ type StorageData struct {
 	AclKeys   []AclKey              `json:"aclKeys,omitempty"`
 }
 
+// Empty reports whether the record does not grant access through any key any more.
+func (s *StorageData) Empty() bool {
+	return !s.Flags.MasterKey && len(s.TimeKeys) == 0 && len(s.AclKeys) == 0
+}
+
+// Equal reports whether both records grant the same access, ignoring the response status.
+func (s *StorageData) Equal(other *StorageData) bool {
+	return reflect.DeepEqual(s.normalized(), other.normalized())
+}
+
+func (s *StorageData) normalized() StorageData {
+	n := *s
+	n.Status = StorageResponseStatusOk
+
+	if len(n.MasterKey.ChannelIds) == 0 {
+		n.MasterKey.ChannelIds = nil
+	}
+
+	if len(n.TimeKeys) == 0 {
+		n.TimeKeys = nil
+	} else {
+		n.TimeKeys = append([]TimeKey(nil), n.TimeKeys...)
+		for i := range n.TimeKeys {
+			if len(n.TimeKeys[i].ChannelIds) == 0 {
+				n.TimeKeys[i].ChannelIds = nil
+			}
+		}
+	}
+
+	if len(n.AclKeys) == 0 {
+		n.AclKeys = nil
+	} else {
+		n.AclKeys = append([]AclKey(nil), n.AclKeys...)
+		for i := range n.AclKeys {
+			if len(n.AclKeys[i].ChannelIds) == 0 {
+				n.AclKeys[i].ChannelIds = nil
+			}
+
+			n.AclKeys[i].canonicalize()
+		}
+	}
+
+	return n
+}
+
 func (s *StorageData) UnmarshalJSON(bytes []byte) error {
 	type storageData StorageData
 
@@ -0,0 +1,173 @@
+package messages
+
+import (
+	"context"
+	"time"
+)
+
+type SweepRecord struct {
+	ExtAddr string                `json:"extAddr"`
+	HashKey string                `json:"hashKey"`
+	Removed []TimeKey             `json:"removed"`
+	Deleted bool                  `json:"deleted"`
+	Status  storageResponseStatus `json:"status"`
+	Error   string                `json:"error,omitempty"`
+	At      time.Time             `json:"at"`
+}
+
+// DefaultSweepInterval is used when a KeySweeper or HolidayScheduler has no Interval.
+const DefaultSweepInterval = time.Hour
+
+// KeySweeper removes expired time keys from the records the Registry knows on every device. A record left without
+// any key is deleted. Devices the Directory reports offline are skipped until a later sweep, and consecutive
+// storage requests are at least MinRequestInterval apart.
+type KeySweeper struct {
+	Storage            *StorageClient
+	Registry           *KeyRegistry
+	Directory          DeviceDirectory
+	Location           func(extAddr string) *time.Location
+	Interval           time.Duration
+	MinRequestInterval time.Duration
+	OnRemoval          func(SweepRecord)
+	Now                func() time.Time
+
+	limiter rateLimiter
+}
+
+func (s *KeySweeper) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+
+	return time.Now()
+}
+
+func (s *KeySweeper) interval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+
+	return DefaultSweepInterval
+}
+
+// location returns the zone of the device, UTC when Location does not know it.
+func (s *KeySweeper) location(extAddr string) *time.Location {
+	if s.Location != nil {
+		if loc := s.Location(extAddr); loc != nil {
+			return loc
+		}
+	}
+
+	return time.UTC
+}
+
+func (s *KeySweeper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *KeySweeper) Sweep(ctx context.Context) ([]SweepRecord, error) {
+	var records []SweepRecord
+	var failed []string
+
+	for _, extAddr := range s.Registry.Devices() {
+		if s.Directory != nil && !s.Directory.Online(extAddr) {
+			continue
+		}
+
+		clock := s.now().In(s.location(extAddr))
+
+		for _, data := range s.Registry.Keys(extAddr) {
+			record, ok := s.sweep(ctx, extAddr, data, clock)
+
+			if !ok {
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return records, ctx.Err()
+			}
+
+			records = append(records, record)
+
+			if record.Error != "" {
+				failed = append(failed, record.HashKey)
+			}
+
+			if s.OnRemoval != nil {
+				s.OnRemoval(record)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return records, SweepError{HashKeys: failed}
+	}
+
+	return records, nil
+}
+
+func (s *KeySweeper) sweep(ctx context.Context, extAddr string, data StorageData, clock time.Time) (SweepRecord, bool) {
+	record := SweepRecord{ExtAddr: extAddr, HashKey: data.HashKey}
+	kept := make([]TimeKey, 0, len(data.TimeKeys))
+
+	for _, k := range data.TimeKeys {
+		if k.Expired(clock) {
+			record.Removed = append(record.Removed, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+
+	if len(record.Removed) == 0 {
+		return record, false
+	}
+
+	if err := s.limiter.wait(ctx, s.MinRequestInterval); err != nil {
+		return record, true
+	}
+
+	data.TimeKeys = kept
+
+	var rsp *StorageResponse
+	var err error
+
+	if data.Empty() {
+		record.Deleted = true
+		rsp, err = s.Storage.DeleteKey(ctx, extAddr, data.HashKey)
+	} else {
+		rsp, err = s.Storage.UpdateKey(ctx, extAddr, data)
+	}
+
+	record.At = s.now()
+
+	if rsp != nil {
+		record.Status = rsp.Status
+	}
+
+	switch {
+	case isStorageStatus(err, StorageResponseStatusErrorKeyNotFound):
+		record.Deleted = true
+		s.Registry.Remove(extAddr, data.HashKey)
+	case err != nil:
+		record.Error = err.Error()
+	case record.Deleted:
+		s.Registry.Remove(extAddr, data.HashKey)
+	default:
+		s.Registry.Put(extAddr, data)
+	}
+
+	return record, true
+}
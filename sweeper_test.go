@@ -0,0 +1,87 @@
+package messages
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeySweeperRunsWithoutInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	sweeper := &KeySweeper{Storage: &StorageClient{Transport: &fakeStorage{}}, Registry: &KeyRegistry{}}
+
+	if err := sweeper.Run(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRateLimiterSpacesConcurrentCallers(t *testing.T) {
+	var limiter rateLimiter
+
+	start := time.Now()
+	done := make(chan struct{})
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			limiter.wait(context.Background(), 20*time.Millisecond)
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("three requests took %v, want at least 40ms", elapsed)
+	}
+}
+
+func TestKeySweeperRemovesExpiredTimeKeys(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past, _ := NewTimeKey(now.Add(-2*time.Hour), now.Add(-time.Hour), time.UTC)
+	future, _ := NewTimeKey(now.Add(-time.Hour), now.Add(time.Hour), time.UTC)
+
+	device := &fakeStorage{}
+	registry := &KeyRegistry{}
+
+	for _, data := range []StorageData{
+		{HashKey: "gone", TimeKeys: []TimeKey{past}},
+		{HashKey: "mixed", TimeKeys: []TimeKey{past, future}},
+		{HashKey: "current", TimeKeys: []TimeKey{future}},
+	} {
+		device.put("lock", data)
+		registry.Put("lock", data)
+	}
+
+	sweeper := &KeySweeper{
+		Storage:  &StorageClient{Transport: device},
+		Registry: registry,
+		// The sweeper does not know the zone of the device and falls back to UTC.
+		Location: func(string) *time.Location { return nil },
+		Now:      func() time.Time { return now },
+	}
+
+	records, err := sweeper.Sweep(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[0].HashKey != "gone" || !records[0].Deleted || records[1].HashKey != "mixed" || records[1].Deleted {
+		t.Fatalf("records %+v", records)
+	}
+
+	if _, ok := device.key("lock", "gone"); ok {
+		t.Fatal("record without keys left is still on the device")
+	}
+
+	if data, _ := device.key("lock", "mixed"); len(data.TimeKeys) != 1 || data.TimeKeys[0].EndTime != future.EndTime {
+		t.Fatalf("mixed record holds %+v", data.TimeKeys)
+	}
+
+	if data, _ := registry.Get("lock", "current"); len(data.TimeKeys) != 1 {
+		t.Fatal("a record without expired keys was changed")
+	}
+}
@@ -0,0 +1,46 @@
+package messages
+
+import "time"
+
+// NewTimeKey converts start and end to TimeKey bounds, which are wall clock times of the device encoded as
+// seconds since 1970-01-01 00:00 in its own time zone loc.
+func NewTimeKey(start, end time.Time, loc *time.Location, channelIds ...int) (TimeKey, error) {
+	k := TimeKey{
+		StartTime:  int(deviceSeconds(start, loc)),
+		EndTime:    int(deviceSeconds(end, loc)),
+		ChannelIds: channelIds,
+	}
+
+	return k, k.Validate()
+}
+
+func (k *TimeKey) Start(loc *time.Location) time.Time { return deviceTime(int64(k.StartTime), loc) }
+func (k *TimeKey) End(loc *time.Location) time.Time   { return deviceTime(int64(k.EndTime), loc) }
+
+func (k *TimeKey) Validate() error {
+	if k.StartTime >= k.EndTime {
+		return InvalidTimeKey{*k}
+	}
+
+	return nil
+}
+
+// Expired reports whether the key can never open again for a device whose clock shows deviceClock.
+func (k *TimeKey) Expired(deviceClock time.Time) bool {
+	return int64(k.EndTime) <= deviceSeconds(deviceClock, deviceClock.Location())
+}
+
+func (k *TimeKey) Active(deviceClock time.Time) bool {
+	now := deviceSeconds(deviceClock, deviceClock.Location())
+	return int64(k.StartTime) <= now && now < int64(k.EndTime)
+}
+
+func deviceSeconds(t time.Time, loc *time.Location) int64 {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Unix()
+}
+
+func deviceTime(seconds int64, loc *time.Location) time.Time {
+	u := time.Unix(seconds, 0).UTC()
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+}
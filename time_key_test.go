@@ -0,0 +1,71 @@
+package messages
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceSecondsRoundTrip(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	for _, loc := range []*time.Location{time.UTC, berlin, DeviceLocation(-5 * time.Hour)} {
+		for _, at := range []time.Time{
+			time.Date(2024, 1, 15, 8, 30, 0, 0, loc),
+			time.Date(2024, 7, 15, 23, 59, 59, 0, loc),
+			time.Date(2024, 3, 31, 1, 0, 0, 0, loc),
+		} {
+			seconds := deviceSeconds(at, loc)
+
+			if wall := time.Unix(seconds, 0).UTC(); wall.Hour() != at.Hour() || wall.Minute() != at.Minute() || wall.Day() != at.Day() {
+				t.Fatalf("%v in %v is encoded as wall clock %v", at, loc, wall)
+			}
+
+			if back := deviceTime(seconds, loc); !back.Equal(at) {
+				t.Fatalf("%v in %v came back as %v", at, loc, back)
+			}
+		}
+	}
+}
+
+func TestTimeKeyBounds(t *testing.T) {
+	loc := DeviceLocation(2 * time.Hour)
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+
+	k, err := NewTimeKey(start, end, loc, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.StartTime != int(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Unix()) {
+		t.Fatalf("start %d is not the wall clock of the device", k.StartTime)
+	}
+
+	if !k.Start(loc).Equal(start) || !k.End(loc).Equal(end) {
+		t.Fatalf("bounds %v-%v, want %v-%v", k.Start(loc), k.End(loc), start, end)
+	}
+
+	if !k.Active(start.In(loc)) || k.Active(end.In(loc)) || k.Expired(start.In(loc)) || !k.Expired(end.In(loc)) {
+		t.Fatal("key is not active exactly between its bounds")
+	}
+
+	if _, err = NewTimeKey(end, start, loc); err == nil {
+		t.Fatal("a key ending before it starts was accepted")
+	}
+}
+
+func TestDeviceStatusClock(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	status := &DeviceStatusResponse{Time: at.Unix(), Timezone: 120}
+
+	if clock := status.Clock(time.Minute); !clock.Equal(at.Add(-2 * time.Hour)) {
+		t.Fatalf("clock %v", clock)
+	}
+
+	if _, offset := status.Clock(time.Minute).Zone(); offset != 7200 {
+		t.Fatalf("offset %d", offset)
+	}
+}
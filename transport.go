@@ -0,0 +1,93 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Transport delivers request to the device with the given extended address and decodes its reply into response.
+type Transport interface {
+	Request(ctx context.Context, extAddr string, request json.Marshaler, response json.Unmarshaler) error
+}
+
+type StorageClient struct {
+	Transport     Transport
+	transactionId uint32
+}
+
+func (c *StorageClient) nextTransactionId() uint32 { return atomic.AddUint32(&c.transactionId, 1) }
+
+func (c *StorageClient) AddKey(ctx context.Context, extAddr string, data StorageData) (*StorageResponse, error) {
+	return c.request(ctx, extAddr, data.HashKey, &StorageAddKey{TransactionId: c.nextTransactionId(), StorageData: data})
+}
+
+func (c *StorageClient) UpdateKey(ctx context.Context, extAddr string, data StorageData) (*StorageResponse, error) {
+	return c.request(ctx, extAddr, data.HashKey, &StorageUpdateKey{TransactionId: c.nextTransactionId(), StorageData: data})
+}
+
+func (c *StorageClient) GetKey(ctx context.Context, extAddr, hashKey string) (*StorageResponse, error) {
+	return c.request(ctx, extAddr, hashKey, &StorageGetKey{TransactionId: c.nextTransactionId(), HashKey: hashKey})
+}
+
+func (c *StorageClient) DeleteKey(ctx context.Context, extAddr, hashKey string) (*StorageResponse, error) {
+	return c.request(ctx, extAddr, hashKey, &StorageDeleteKey{TransactionId: c.nextTransactionId(), HashKey: hashKey})
+}
+
+func (c *StorageClient) request(ctx context.Context, extAddr, hashKey string, request json.Marshaler) (*StorageResponse, error) {
+	var r StorageResponse
+
+	if err := c.Transport.Request(ctx, extAddr, request, &r); err != nil {
+		return nil, err
+	}
+
+	if !r.Status.Ok() {
+		return &r, StorageStatusError{ExtAddr: extAddr, HashKey: hashKey, Status: r.Status}
+	}
+
+	return &r, nil
+}
+
+func isStorageStatus(err error, status storageResponseStatus) bool {
+	var statusErr StorageStatusError
+	return errors.As(err, &statusErr) && statusErr.Status == status
+}
+
+// rateLimiter spaces requests at least interval apart. Concurrent callers each reserve their own slot.
+type rateLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *rateLimiter) wait(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return ctx.Err()
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(interval)
+	l.mu.Unlock()
+
+	if delay := at.Sub(now); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return ctx.Err()
+}
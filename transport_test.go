@@ -0,0 +1,112 @@
+package messages
+
+import (
+	"context"
+	"sync"
+
+	"github.com/goccy/go-json"
+)
+
+// fakeStorage is a Transport serving the local storage requests of any number of devices from memory.
+type fakeStorage struct {
+	mu      sync.Mutex
+	devices map[string]map[string]StorageData
+	deletes int
+
+	// refuse makes a device answer every request with the given status.
+	refuse map[string]storageResponseStatus
+}
+
+func (f *fakeStorage) key(extAddr, hashKey string) (StorageData, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.devices[extAddr][hashKey]
+	return data, ok
+}
+
+func (f *fakeStorage) put(extAddr string, data StorageData) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.devices == nil {
+		f.devices = make(map[string]map[string]StorageData)
+	}
+
+	if f.devices[extAddr] == nil {
+		f.devices[extAddr] = make(map[string]StorageData)
+	}
+
+	f.devices[extAddr][data.HashKey] = data
+}
+
+func (f *fakeStorage) Request(_ context.Context, extAddr string, request json.Marshaler, response json.Unmarshaler) error {
+	rsp := response.(*StorageResponse)
+	rsp.ExtAddr = extAddr
+
+	var hashKey string
+	var data *StorageData
+
+	switch r := request.(type) {
+	case *StorageAddKey:
+		hashKey, data = r.HashKey, &r.StorageData
+	case *StorageUpdateKey:
+		hashKey, data = r.HashKey, &r.StorageData
+	case *StorageGetKey:
+		hashKey = r.HashKey
+	case *StorageDeleteKey:
+		hashKey = r.HashKey
+	}
+
+	existing, ok := f.key(extAddr, hashKey)
+	rsp.HashKey = hashKey
+
+	f.mu.Lock()
+	status, refused := f.refuse[extAddr]
+	f.mu.Unlock()
+
+	if refused {
+		rsp.Status = status
+		return nil
+	}
+
+	switch request.(type) {
+	case *StorageAddKey:
+		if ok {
+			rsp.Status = StorageResponseStatusErrorKeyAlreadyExists
+			return nil
+		}
+
+		f.put(extAddr, *data)
+	case *StorageUpdateKey:
+		if !ok {
+			rsp.Status = StorageResponseStatusErrorKeyNotFound
+			return nil
+		}
+
+		f.put(extAddr, *data)
+	case *StorageGetKey:
+		if !ok {
+			rsp.Status = StorageResponseStatusErrorKeyNotFound
+			return nil
+		}
+
+		rsp.StorageData = existing
+		rsp.Status = StorageResponseStatusReadOk
+		return nil
+	case *StorageDeleteKey:
+		if !ok {
+			rsp.Status = StorageResponseStatusErrorKeyNotFound
+			return nil
+		}
+
+		f.mu.Lock()
+		delete(f.devices[extAddr], hashKey)
+		f.deletes++
+		f.mu.Unlock()
+	}
+
+	rsp.Status = StorageResponseStatusOk
+
+	return nil
+}
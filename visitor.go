@@ -0,0 +1,406 @@
+package messages
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+const (
+	VisitorQuotaReached visitorRetireReason = "quotaReached"
+	VisitorExpired      visitorRetireReason = "expired"
+	VisitorRevoked      visitorRetireReason = "revoked"
+)
+
+type visitorRetireReason string
+
+type VisitorCredential struct {
+	HashKey   string              `json:"hashKey"`
+	Devices   []string            `json:"devices"`
+	MaxUses   int                 `json:"maxUses"`
+	Uses      int                 `json:"uses"`
+	IssuedAt  time.Time           `json:"issuedAt"`
+	ExpiresAt time.Time           `json:"expiresAt"`
+	Retired   visitorRetireReason `json:"retired,omitempty"`
+
+	// retiring is set while one caller deletes the credential from its devices.
+	retiring bool
+}
+
+// VisitorStore keeps the issued credentials with their use counts.
+type VisitorStore interface {
+	Load() ([]VisitorCredential, error)
+	Save(credential VisitorCredential) error
+	Delete(hashKey string) error
+}
+
+// FileVisitorStore keeps all credentials in one JSON file at Path, rewritten on every change.
+type FileVisitorStore struct {
+	Path string
+
+	records recordFile
+}
+
+func (s *FileVisitorStore) Load() ([]VisitorCredential, error) {
+	var credentials []VisitorCredential
+
+	err := s.records.load(s.Path, func(record []byte) error {
+		var c VisitorCredential
+
+		if err := json.Unmarshal(record, &c); err != nil {
+			return err
+		}
+
+		credentials = append(credentials, c)
+
+		return nil
+	})
+
+	return credentials, err
+}
+
+func (s *FileVisitorStore) Save(credential VisitorCredential) error {
+	return s.records.put(s.Path, credential.HashKey, credential)
+}
+
+func (s *FileVisitorStore) Delete(hashKey string) error {
+	return s.records.delete(s.Path, hashKey)
+}
+
+type VisitorDeletion struct {
+	ExtAddr string                `json:"extAddr"`
+	Status  storageResponseStatus `json:"status"`
+	Error   string                `json:"error,omitempty"`
+}
+
+type VisitorAuditRecord struct {
+	HashKey   string              `json:"hashKey"`
+	Reason    visitorRetireReason `json:"reason"`
+	Uses      int                 `json:"uses"`
+	MaxUses   int                 `json:"maxUses"`
+	At        time.Time           `json:"at"`
+	Deletions []VisitorDeletion   `json:"deletions"`
+}
+
+// VisitorManager issues credentials that are deleted from every device once they were used MaxUses times
+// or ExpiresAt has passed. Feed it either the AuthRequest or the AuthResponse stream, not both. Every change of
+// a credential is saved to Store, so quotas keep counting across restarts.
+type VisitorManager struct {
+	Storage *StorageClient
+	Store   VisitorStore
+	OnAudit func(VisitorAuditRecord)
+	Now     func() time.Time
+
+	mu          sync.Mutex
+	credentials map[string]*VisitorCredential
+}
+
+func (m *VisitorManager) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+
+	return time.Now()
+}
+
+// load reads the credentials from Store on first use. It must be called with mu held.
+func (m *VisitorManager) load() error {
+	if m.credentials != nil {
+		return nil
+	}
+
+	if m.Store == nil {
+		return StoreMissing{"visitor manager"}
+	}
+
+	credentials, err := m.Store.Load()
+
+	if err != nil {
+		return err
+	}
+
+	m.credentials = make(map[string]*VisitorCredential, len(credentials))
+
+	for i := range credentials {
+		m.credentials[credentials[i].HashKey] = &credentials[i]
+	}
+
+	return nil
+}
+
+// update applies change to a copy of c and keeps it only once Store saved it. It must be called with mu held.
+func (m *VisitorManager) update(c *VisitorCredential, change func(c *VisitorCredential)) error {
+	updated := *c
+	change(&updated)
+
+	if err := m.Store.Save(updated); err != nil {
+		return err
+	}
+
+	*c = updated
+
+	return nil
+}
+
+// Issue saves the credential to Store before adding it to the devices, so a restart in between cannot leave a
+// key on a device that the manager does not know about.
+func (m *VisitorManager) Issue(ctx context.Context, data StorageData, devices []string, maxUses int, expiresAt time.Time) error {
+	credential := &VisitorCredential{
+		HashKey:   data.HashKey,
+		Devices:   append([]string(nil), devices...),
+		MaxUses:   maxUses,
+		IssuedAt:  m.now(),
+		ExpiresAt: expiresAt,
+	}
+
+	m.mu.Lock()
+	err := m.load()
+	if err == nil {
+		err = m.Store.Save(*credential)
+	}
+	if err == nil {
+		m.credentials[data.HashKey] = credential
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	var added []string
+	var errs []error
+
+	for _, extAddr := range devices {
+		if _, err := m.Storage.AddKey(ctx, extAddr, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		added = append(added, extAddr)
+	}
+
+	m.mu.Lock()
+	if len(added) == 0 {
+		err = m.Store.Delete(data.HashKey)
+		if err == nil {
+			delete(m.credentials, data.HashKey)
+		}
+	} else if len(added) < len(devices) {
+		err = m.update(credential, func(c *VisitorCredential) { c.Devices = added })
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return VisitorError{HashKey: data.HashKey, Errors: errs}
+	}
+
+	return nil
+}
+
+func (m *VisitorManager) Credential(hashKey string) (VisitorCredential, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.load() != nil {
+		return VisitorCredential{}, false
+	}
+
+	c, ok := m.credentials[hashKey]
+	if !ok {
+		return VisitorCredential{}, false
+	}
+
+	credential := *c
+	credential.Devices = append([]string(nil), c.Devices...)
+
+	return credential, true
+}
+
+func (m *VisitorManager) ObserveRequest(ctx context.Context, a *AuthRequest) (*VisitorAuditRecord, error) {
+	return m.observe(ctx, a.HashKey, a.AuthStatus)
+}
+
+func (m *VisitorManager) ObserveResponse(ctx context.Context, a *AuthResponse) (*VisitorAuditRecord, error) {
+	return m.observe(ctx, a.HashKey, a.AuthStatus)
+}
+
+func (m *VisitorManager) observe(ctx context.Context, hashKey string, status authStatus) (*VisitorAuditRecord, error) {
+	if !status.Succeeded() {
+		return nil, nil
+	}
+
+	now := m.now()
+
+	m.mu.Lock()
+	if err := m.load(); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	c, ok := m.credentials[hashKey]
+	if !ok || c.Retired != "" {
+		m.mu.Unlock()
+		return nil, nil
+	}
+
+	err := m.update(c, func(c *VisitorCredential) {
+		c.Uses++
+
+		if c.MaxUses > 0 && c.Uses >= c.MaxUses {
+			c.Retired = VisitorQuotaReached
+		} else if !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+			c.Retired = VisitorExpired
+		}
+	})
+
+	retired := c.Retired != ""
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !retired {
+		return nil, nil
+	}
+
+	return m.retire(ctx, hashKey)
+}
+
+// Expire retires every credential whose time limit has passed and retries deletions that failed earlier.
+func (m *VisitorManager) Expire(ctx context.Context) ([]VisitorAuditRecord, error) {
+	now := m.now()
+
+	var due []string
+	var errs []error
+
+	m.mu.Lock()
+	if err := m.load(); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	for hashKey, c := range m.credentials {
+		if c.Retired == "" && !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt) {
+			if err := m.update(c, func(c *VisitorCredential) { c.Retired = VisitorExpired }); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+
+		if c.Retired != "" {
+			due = append(due, hashKey)
+		}
+	}
+	m.mu.Unlock()
+
+	var records []VisitorAuditRecord
+
+	for _, hashKey := range due {
+		record, err := m.retire(ctx, hashKey)
+
+		if record != nil {
+			records = append(records, *record)
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return records, VisitorError{Errors: errs}
+	}
+
+	return records, nil
+}
+
+func (m *VisitorManager) Revoke(ctx context.Context, hashKey string) (*VisitorAuditRecord, error) {
+	m.mu.Lock()
+	if err := m.load(); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	c, ok := m.credentials[hashKey]
+
+	var err error
+	if ok && c.Retired == "" {
+		err = m.update(c, func(c *VisitorCredential) { c.Retired = VisitorRevoked })
+	}
+	m.mu.Unlock()
+
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	return m.retire(ctx, hashKey)
+}
+
+// retire deletes a retired credential from its devices. A credential another caller is retiring right now is
+// left to that caller, so every retirement sends its deletions and its audit record once.
+func (m *VisitorManager) retire(ctx context.Context, hashKey string) (*VisitorAuditRecord, error) {
+	m.mu.Lock()
+	c, ok := m.credentials[hashKey]
+	if !ok || c.retiring {
+		m.mu.Unlock()
+		return nil, nil
+	}
+
+	c.retiring = true
+
+	record := VisitorAuditRecord{HashKey: hashKey, Reason: c.Retired, Uses: c.Uses, MaxUses: c.MaxUses}
+	devices := append([]string(nil), c.Devices...)
+	m.mu.Unlock()
+
+	var remaining []string
+	var errs []error
+
+	for _, extAddr := range devices {
+		deletion := VisitorDeletion{ExtAddr: extAddr}
+		r, err := m.Storage.DeleteKey(ctx, extAddr, hashKey)
+
+		if r != nil {
+			deletion.Status = r.Status
+		}
+
+		if err != nil && !isStorageStatus(err, StorageResponseStatusErrorKeyNotFound) {
+			deletion.Error = err.Error()
+			remaining = append(remaining, extAddr)
+			errs = append(errs, err)
+		}
+
+		record.Deletions = append(record.Deletions, deletion)
+	}
+
+	record.At = m.now()
+
+	m.mu.Lock()
+	if len(remaining) == 0 {
+		if err := m.Store.Delete(hashKey); err != nil {
+			errs = append(errs, err)
+		} else {
+			delete(m.credentials, hashKey)
+		}
+	} else if err := m.update(c, func(c *VisitorCredential) { c.Devices = remaining }); err != nil {
+		errs = append(errs, err)
+	}
+	c.retiring = false
+	m.mu.Unlock()
+
+	if m.OnAudit != nil {
+		m.OnAudit(record)
+	}
+
+	if len(errs) > 0 {
+		return &record, VisitorError{HashKey: hashKey, Errors: errs}
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,111 @@
+package messages
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVisitorQuotaSurvivesRestart(t *testing.T) {
+	device := &fakeStorage{}
+	path := filepath.Join(t.TempDir(), "visitors.json")
+	ctx := context.Background()
+
+	manager := &VisitorManager{Storage: &StorageClient{Transport: device}, Store: &FileVisitorStore{Path: path}}
+
+	if err := manager.Issue(ctx, StorageData{HashKey: "qr"}, []string{"lock"}, 2, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := manager.ObserveResponse(ctx, &AuthResponse{HashKey: "qr", AuthStatus: SuccessOfflineStatus}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &VisitorManager{Storage: &StorageClient{Transport: device}, Store: &FileVisitorStore{Path: path}}
+
+	record, err := restarted.ObserveResponse(ctx, &AuthResponse{HashKey: "qr", AuthStatus: SuccessOfflineStatus})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record == nil || record.Reason != VisitorQuotaReached || record.Uses != 2 {
+		t.Fatalf("record = %+v, want the quota reached after 2 uses", record)
+	}
+
+	if _, ok := device.key("lock", "qr"); ok {
+		t.Fatal("credential is still on the device")
+	}
+}
+
+func TestVisitorRetiresOnce(t *testing.T) {
+	device := &fakeStorage{}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var audits int
+
+	manager := &VisitorManager{
+		Storage: &StorageClient{Transport: device},
+		Store:   &FileVisitorStore{Path: filepath.Join(t.TempDir(), "visitors.json")},
+		OnAudit: func(VisitorAuditRecord) { mu.Lock(); audits++; mu.Unlock() },
+		Now:     func() time.Time { return now },
+	}
+
+	if err := manager.Issue(ctx, StorageData{HashKey: "qr"}, []string{"a", "b"}, 1, now); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		manager.ObserveResponse(ctx, &AuthResponse{HashKey: "qr", AuthStatus: SuccessOfflineStatus})
+	}()
+
+	go func() {
+		defer wg.Done()
+		manager.Expire(ctx)
+	}()
+
+	wg.Wait()
+
+	if audits != 1 || device.deletes != 2 {
+		t.Fatalf("%d audit records and %d deletions, want 1 and 2", audits, device.deletes)
+	}
+}
+
+func TestVisitorRetiresAtQuota(t *testing.T) {
+	device := &fakeStorage{}
+	ctx := context.Background()
+
+	manager := &VisitorManager{Storage: &StorageClient{Transport: device}, Store: &FileVisitorStore{Path: filepath.Join(t.TempDir(), "visitors.json")}}
+
+	if err := manager.Issue(ctx, StorageData{HashKey: "qr"}, []string{"a", "b"}, 2, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for uses := 1; uses <= 2; uses++ {
+		record, err := manager.ObserveResponse(ctx, &AuthResponse{HashKey: "qr", AuthStatus: SuccessOfflineStatus})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (record != nil) != (uses == 2) {
+			t.Fatalf("use %d retired the credential: %+v", uses, record)
+		}
+
+		if record != nil && (record.Reason != VisitorQuotaReached || len(record.Deletions) != 2) {
+			t.Fatalf("record = %+v, want the quota reached and 2 deletions", record)
+		}
+	}
+
+	if _, ok := device.key("a", "qr"); ok {
+		t.Fatal("credential is still on device a")
+	}
+}